@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andig/gravo/interval"
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// aggregateFunc transforms the non-null tuples of a series
+type aggregateFunc func(data []volkszaehler.Tuple, args []string) ([]volkszaehler.Tuple, error)
+
+// aggregates are the supported functions of the aggregate payload field
+var aggregates = map[string]aggregateFunc{
+	"movingAverage":        movingAverage,
+	"exponentialSmoothing": exponentialSmoothing,
+	"percentile":           percentile,
+	"derivative":           derivative,
+	"rate":                 rate,
+	"integral":             integral,
+	"rollingSum":           rollingSum,
+}
+
+var aggregateRE = regexp.MustCompile(`^(\w+)\s*(?:\((.*)\))?$`)
+
+// aggregate applies the |-separated aggregate functions like
+// movingAverage(15m)|rate(1h) in order. Null tuples are passed through
+// unchanged. On error, data is returned unchanged.
+func aggregate(data []volkszaehler.Tuple, expr string) ([]volkszaehler.Tuple, error) {
+	if strings.TrimSpace(expr) == "" {
+		return data, nil
+	}
+
+	res := data
+	for _, call := range strings.Split(expr, "|") {
+		match := aggregateRE.FindStringSubmatch(strings.TrimSpace(call))
+		if match == nil {
+			return data, fmt.Errorf("invalid aggregate: %s", call)
+		}
+
+		fun, ok := aggregates[match[1]]
+		if !ok {
+			return data, fmt.Errorf("unsupported aggregate: %s", match[1])
+		}
+
+		var args []string
+		if match[2] != "" {
+			for _, arg := range strings.Split(match[2], ",") {
+				args = append(args, strings.TrimSpace(arg))
+			}
+		}
+
+		var values, nulls []volkszaehler.Tuple
+		for _, tuple := range res {
+			if tuple.Null {
+				nulls = append(nulls, tuple)
+			} else {
+				values = append(values, tuple)
+			}
+		}
+
+		values, err := fun(values, args)
+		if err != nil {
+			return data, fmt.Errorf("%s: %v", match[1], err)
+		}
+
+		res = append(values, nulls...)
+		sort.SliceStable(res, func(i, j int) bool {
+			return res[i].Timestamp < res[j].Timestamp
+		})
+	}
+
+	return res, nil
+}
+
+// durationArg returns the duration argument at idx or def if missing
+func durationArg(args []string, idx int, def time.Duration) (time.Duration, error) {
+	if idx >= len(args) || args[idx] == "" {
+		if def == 0 {
+			return 0, fmt.Errorf("argument %d: duration expected", idx+1)
+		}
+		return def, nil
+	}
+
+	d, err := interval.Parse(args[idx])
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("argument %d: invalid duration: %s", idx+1, args[idx])
+	}
+
+	return d, nil
+}
+
+// floatArg returns the numeric argument at idx within [min, max]
+func floatArg(args []string, idx int, min, max float64) (float64, error) {
+	if idx >= len(args) {
+		return 0, fmt.Errorf("argument %d: number expected", idx+1)
+	}
+
+	f, err := strconv.ParseFloat(args[idx], 64)
+	if err != nil || f < min || f > max {
+		return 0, fmt.Errorf("argument %d: invalid number: %s", idx+1, args[idx])
+	}
+
+	return f, nil
+}
+
+// window calls fun with the values of the trailing window ending at each
+// tuple
+func window(data []volkszaehler.Tuple, size time.Duration, fun func(values []float64) float64) []volkszaehler.Tuple {
+	ms := int64(size / time.Millisecond)
+	res := make([]volkszaehler.Tuple, len(data))
+
+	var start int
+	for i, tuple := range data {
+		for data[start].Timestamp <= tuple.Timestamp-ms {
+			start++
+		}
+
+		values := make([]float64, 0, i-start+1)
+		for _, t := range data[start : i+1] {
+			values = append(values, t.Value)
+		}
+
+		res[i] = tuple
+		res[i].Value = fun(values)
+	}
+
+	return res
+}
+
+// movingAverage(window) averages the values of the trailing window
+func movingAverage(data []volkszaehler.Tuple, args []string) ([]volkszaehler.Tuple, error) {
+	size, err := durationArg(args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return window(data, size, func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	}), nil
+}
+
+// rollingSum(window) sums the values of the trailing window
+func rollingSum(data []volkszaehler.Tuple, args []string) ([]volkszaehler.Tuple, error) {
+	size, err := durationArg(args, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return window(data, size, func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	}), nil
+}
+
+// percentile(p, window) returns the p-th percentile of the trailing window
+// using nearest rank
+func percentile(data []volkszaehler.Tuple, args []string) ([]volkszaehler.Tuple, error) {
+	p, err := floatArg(args, 0, 0, 100)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := durationArg(args, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return window(data, size, func(values []float64) float64 {
+		sort.Float64s(values)
+		rank := int(math.Ceil(p/100*float64(len(values)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		return values[rank]
+	}), nil
+}
+
+// exponentialSmoothing(alpha) smoothes values with factor 0 < alpha <= 1
+func exponentialSmoothing(data []volkszaehler.Tuple, args []string) ([]volkszaehler.Tuple, error) {
+	alpha, err := floatArg(args, 0, 0, 1)
+	if err != nil || alpha == 0 {
+		return nil, fmt.Errorf("argument 1: alpha between 0 and 1 expected")
+	}
+
+	res := make([]volkszaehler.Tuple, len(data))
+	for i, tuple := range data {
+		res[i] = tuple
+		if i > 0 {
+			res[i].Value = alpha*tuple.Value + (1-alpha)*res[i-1].Value
+		}
+	}
+
+	return res, nil
+}
+
+// derivative returns the difference to the previous value. The first
+// tuple is dropped.
+func derivative(data []volkszaehler.Tuple, args []string) ([]volkszaehler.Tuple, error) {
+	res := make([]volkszaehler.Tuple, 0, len(data))
+	for i := 1; i < len(data); i++ {
+		tuple := data[i]
+		tuple.Value -= data[i-1].Value
+		res = append(res, tuple)
+	}
+
+	return res, nil
+}
+
+// rate(unit) returns the change per unit, default per second. The first
+// tuple is dropped.
+func rate(data []volkszaehler.Tuple, args []string) ([]volkszaehler.Tuple, error) {
+	unit, err := durationArg(args, 0, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]volkszaehler.Tuple, 0, len(data))
+	for i := 1; i < len(data); i++ {
+		dt := float64(data[i].Timestamp-data[i-1].Timestamp) / float64(unit/time.Millisecond)
+		if dt <= 0 {
+			continue
+		}
+
+		tuple := data[i]
+		tuple.Value = (tuple.Value - data[i-1].Value) / dt
+		res = append(res, tuple)
+	}
+
+	return res, nil
+}
+
+// integral(unit) returns the running integral of values over time, e.g.
+// integral(1h) converts W into Wh. Like volkszaehler tuples, each value is
+// the average since the previous tuple.
+func integral(data []volkszaehler.Tuple, args []string) ([]volkszaehler.Tuple, error) {
+	unit, err := durationArg(args, 0, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]volkszaehler.Tuple, len(data))
+
+	var sum float64
+	for i, tuple := range data {
+		if i > 0 {
+			dt := float64(tuple.Timestamp-data[i-1].Timestamp) / float64(unit/time.Millisecond)
+			sum += tuple.Value * dt
+		}
+
+		res[i] = tuple
+		res[i].Value = sum
+	}
+
+	return res, nil
+}
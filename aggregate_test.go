@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// minutes creates tuples at the given minutes, NaN values are null
+func minutes(values ...float64) []volkszaehler.Tuple {
+	var res []volkszaehler.Tuple
+	for i, v := range values {
+		tuple := volkszaehler.Tuple{Timestamp: int64(i) * int64(time.Minute/time.Millisecond), Value: v}
+		if math.IsNaN(v) {
+			tuple = volkszaehler.Tuple{Timestamp: tuple.Timestamp, Null: true}
+		}
+		res = append(res, tuple)
+	}
+	return res
+}
+
+// equalTuples compares timestamps, nulls and values within tolerance
+func equalTuples(a, b []volkszaehler.Tuple) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Timestamp != b[i].Timestamp || a[i].Null != b[i].Null || math.Abs(a[i].Value-b[i].Value) > 1e-9 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestAggregate(t *testing.T) {
+	nan := math.NaN()
+	data := minutes(1, 2, 4, nan, 8)
+
+	// at returns the tuple at minute with value
+	at := func(minute int, value float64) volkszaehler.Tuple {
+		return volkszaehler.Tuple{Timestamp: int64(minute) * int64(time.Minute/time.Millisecond), Value: value}
+	}
+	null := volkszaehler.Tuple{Timestamp: 3 * int64(time.Minute/time.Millisecond), Null: true}
+
+	tc := []struct {
+		expr     string
+		data     []volkszaehler.Tuple
+		expected []volkszaehler.Tuple
+	}{
+		{"", data, data},
+		// window excludes values at window start
+		{"movingAverage(2m)", data, []volkszaehler.Tuple{at(0, 1), at(1, 1.5), at(2, 3), null, at(4, 8)}},
+		{"rollingSum(2m)", data, []volkszaehler.Tuple{at(0, 1), at(1, 3), at(2, 6), null, at(4, 8)}},
+		{"rollingSum(1h)", data, []volkszaehler.Tuple{at(0, 1), at(1, 3), at(2, 7), null, at(4, 15)}},
+		// nearest rank
+		{"percentile(50, 10m)", data, []volkszaehler.Tuple{at(0, 1), at(1, 1), at(2, 2), null, at(4, 2)}},
+		{"percentile(100, 10m)", data, []volkszaehler.Tuple{at(0, 1), at(1, 2), at(2, 4), null, at(4, 8)}},
+		{"percentile(0, 10m)", data, []volkszaehler.Tuple{at(0, 1), at(1, 1), at(2, 1), null, at(4, 1)}},
+		{"percentile(75, 2m)", data, []volkszaehler.Tuple{at(0, 1), at(1, 2), at(2, 4), null, at(4, 8)}},
+		{"exponentialSmoothing(0.5)", data, []volkszaehler.Tuple{at(0, 1), at(1, 1.5), at(2, 2.75), null, at(4, 5.375)}},
+		{"exponentialSmoothing(1)", data, data},
+		{"derivative", data, []volkszaehler.Tuple{at(1, 1), at(2, 2), null, at(4, 4)}},
+		{"rate(1m)", data, []volkszaehler.Tuple{at(1, 1), at(2, 2), null, at(4, 2)}},
+		{"rate", data, []volkszaehler.Tuple{at(1, 1.0/60), at(2, 2.0/60), null, at(4, 2.0/60)}},
+		{"integral(1m)", data, []volkszaehler.Tuple{at(0, 0), at(1, 2), at(2, 6), null, at(4, 22)}},
+		{"integral", data, []volkszaehler.Tuple{at(0, 0), at(1, 2.0/60), at(2, 6.0/60), null, at(4, 22.0/60)}},
+		{"derivative | rollingSum(10m)", data, []volkszaehler.Tuple{at(1, 1), at(2, 3), null, at(4, 7)}},
+		{"rate(1m)", minutes(1), []volkszaehler.Tuple{}},
+		{"movingAverage(1m)", nil, []volkszaehler.Tuple{}},
+		{"derivative", minutes(nan, nan), minutes(nan, nan)},
+	}
+
+	for _, c := range tc {
+		res, err := aggregate(c.data, c.expr)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.expr, err)
+			continue
+		}
+
+		if !equalTuples(res, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.expr, c.expected, res)
+		}
+	}
+}
+
+func TestAggregateInvalid(t *testing.T) {
+	data := minutes(1, 2, 3)
+
+	for _, expr := range []string{
+		"foo",
+		"movingAverage(1h",
+		"movingAverage",
+		"movingAverage(x)",
+		"movingAverage(-1h)",
+		"percentile(101, 1h)",
+		"percentile(50)",
+		"exponentialSmoothing(0)",
+		"exponentialSmoothing(2)",
+		"rate(0s)",
+		"derivative|foo",
+	} {
+		res, err := aggregate(data, expr)
+		if err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+
+		if !equalTuples(res, data) {
+			t.Errorf("%s: expected unchanged data, got %v", expr, res)
+		}
+	}
+}
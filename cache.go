@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// entitySnapshot is an immutable view of the flattened public entity tree.
+// It is replaced as a whole on refresh and never modified afterwards.
+type entitySnapshot struct {
+	entities []volkszaehler.Entity
+	byUUID   map[string]volkszaehler.Entity
+}
+
+func newEntitySnapshot(entities []volkszaehler.Entity) *entitySnapshot {
+	snapshot := &entitySnapshot{
+		entities: entities,
+		byUUID:   make(map[string]volkszaehler.Entity, len(entities)),
+	}
+
+	for _, entity := range entities {
+		if _, ok := snapshot.byUUID[entity.UUID]; !ok {
+			snapshot.byUUID[entity.UUID] = entity
+		}
+	}
+
+	return snapshot
+}
+
+// entityCache holds the last good entity snapshot. Reads are lock-free,
+// updates are serialized.
+type entityCache struct {
+	mux      sync.Mutex   // guards updates
+	snapshot atomic.Value // holds *entitySnapshot
+}
+
+func newEntityCache() *entityCache {
+	cache := &entityCache{}
+	cache.snapshot.Store(newEntitySnapshot(nil))
+	return cache
+}
+
+func (cache *entityCache) load() *entitySnapshot {
+	return cache.snapshot.Load().(*entitySnapshot)
+}
+
+// entities returns the cached flattened entities
+func (cache *entityCache) entities() []volkszaehler.Entity {
+	return cache.load().entities
+}
+
+// entity returns the cached entity for uuid
+func (cache *entityCache) entity(uuid string) (volkszaehler.Entity, bool) {
+	entity, ok := cache.load().byUUID[uuid]
+	return entity, ok
+}
+
+// update replaces the cached snapshot and returns added and removed entities
+func (cache *entityCache) update(entities []volkszaehler.Entity) (added, removed []volkszaehler.Entity) {
+	cache.mux.Lock()
+	defer cache.mux.Unlock()
+
+	current := newEntitySnapshot(entities)
+	previous := cache.load()
+	cache.snapshot.Store(current)
+
+	for _, entity := range current.entities {
+		if _, ok := previous.byUUID[entity.UUID]; !ok {
+			added = append(added, entity)
+		}
+	}
+
+	for _, entity := range previous.entities {
+		if _, ok := current.byUUID[entity.UUID]; !ok {
+			removed = append(removed, entity)
+		}
+	}
+
+	return added, removed
+}
+
+// refreshEntities updates the entity cache from the middleware. On failure
+// the previous entity tree is retained.
+func (server *Server) refreshEntities() error {
+	publicEntities, err := server.api.QueryPublicEntities()
+	if err != nil {
+		return err
+	}
+
+	entities := make([]volkszaehler.Entity, 0)
+	flattenEntities(&entities, publicEntities, "")
+
+	added, removed := server.cache.update(entities)
+	for _, entity := range added {
+		log.Printf("entity added: %s (%s)", entity.Title, entity.UUID)
+	}
+	for _, entity := range removed {
+		log.Printf("entity removed: %s (%s)", entity.Title, entity.UUID)
+	}
+
+	return nil
+}
+
+// refresh periodically updates the entity cache until the server is closed
+func (server *Server) refresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := server.refreshEntities(); err != nil {
+				log.Printf("entity refresh failed: %v", err)
+			}
+		case <-server.done:
+			return
+		}
+	}
+}
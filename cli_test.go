@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestCommandFlagSet(t *testing.T) {
+	for _, name := range commands {
+		fs, _, err := newCommandFlagSet(name)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+
+		for _, shared := range sharedFlags {
+			if fs.Lookup(shared) == nil {
+				t.Errorf("%s: missing shared flag %s", name, shared)
+			}
+		}
+
+		// server flags are not accepted after the command
+		if fs.Lookup("mqtt") != nil || fs.Lookup("ingest") != nil {
+			t.Errorf("%s: unexpected server flags", name)
+		}
+	}
+
+	if _, _, err := newCommandFlagSet("unknown"); err == nil {
+		t.Error("expected unknown command error")
+	}
+}
+
+func TestCommandSharedFlags(t *testing.T) {
+	api := *apiURL
+	defer func() { *apiURL = api }()
+
+	fs, cf, err := newCommandFlagSet("query")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	positional, err := parseCommandArgs(fs, []string{"House/Grid", "-api", "http://localhost/middleware.php", "-group", "day"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(positional) != 1 || positional[0] != "House/Grid" {
+		t.Errorf("unexpected positional args: %v", positional)
+	}
+
+	if *apiURL != "http://localhost/middleware.php" {
+		t.Errorf("shared flag not applied to global: %s", *apiURL)
+	}
+
+	if *cf.group != "day" {
+		t.Errorf("unexpected group: %s", *cf.group)
+	}
+
+	if flag.Lookup("group") != nil {
+		t.Error("command flag leaked into global flags")
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/andig/gravo/grafana"
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// generated panel size, two panels per row
+const (
+	panelWidth  = 12
+	panelHeight = 8
+)
+
+// dashboardBuilder lays out panels in the dashboard grid
+type dashboardBuilder struct {
+	dashboard  grafana.Dashboard
+	datasource string
+	id         int
+	x, y       int
+}
+
+// newDashboard generates a dashboard from the public entity tree with one
+// row per group and panels per channel depending on the entity type
+func newDashboard(entities []volkszaehler.Entity, title, uid, datasource string) grafana.Dashboard {
+	b := &dashboardBuilder{
+		dashboard: grafana.Dashboard{
+			UID:           uid,
+			Title:         title,
+			Tags:          []string{"volkszaehler", "gravo"},
+			Editable:      true,
+			SchemaVersion: 27,
+			Time:          grafana.RelativeRange{From: "now-24h", To: "now"},
+			Refresh:       "1m",
+			Panels:        []grafana.Panel{},
+		},
+		datasource: datasource,
+	}
+
+	var channels []volkszaehler.Entity
+	flattenEntities(&channels, entities, "")
+
+	// group channels by parent path preserving tree order
+	var groups []string
+	members := make(map[string][]volkszaehler.Entity)
+
+	for _, channel := range channels {
+		var group string
+		if idx := strings.LastIndex(channel.Title, "/"); idx >= 0 {
+			group = channel.Title[:idx]
+		}
+
+		if _, ok := members[group]; !ok {
+			groups = append(groups, group)
+		}
+		members[group] = append(members[group], channel)
+	}
+
+	for _, group := range groups {
+		title := group
+		if title == "" {
+			title = "Channels"
+		}
+		b.row(title)
+
+		for _, channel := range members[group] {
+			for _, panel := range b.channelPanels(channel) {
+				b.add(panel)
+			}
+		}
+	}
+
+	return b.dashboard
+}
+
+// row starts a new dashboard row
+func (b *dashboardBuilder) row(title string) {
+	if b.x > 0 {
+		b.x = 0
+		b.y += panelHeight
+	}
+
+	b.id++
+	b.dashboard.Panels = append(b.dashboard.Panels, grafana.Panel{
+		ID:      b.id,
+		Type:    "row",
+		Title:   title,
+		GridPos: grafana.GridPos{H: 1, W: 24, X: 0, Y: b.y},
+	})
+
+	b.y++
+}
+
+// add places the panel in the next free grid position
+func (b *dashboardBuilder) add(panel grafana.Panel) {
+	b.id++
+	panel.ID = b.id
+	panel.Datasource = b.datasource
+	panel.GridPos = grafana.GridPos{H: panelHeight, W: panelWidth, X: b.x, Y: b.y}
+
+	b.dashboard.Panels = append(b.dashboard.Panels, panel)
+
+	if b.x += panelWidth; b.x >= 24 {
+		b.x = 0
+		b.y += panelHeight
+	}
+}
+
+// channelPanels returns the panels for a channel: power graph and daily
+// consumption bars for power channels, gauge for temperatures and a graph
+// for all other channels
+func (b *dashboardBuilder) channelPanels(channel volkszaehler.Entity) []grafana.Panel {
+	name := channel.Title[strings.LastIndex(channel.Title, "/")+1:]
+	unit := units[channel.Type]
+
+	target := grafana.PanelTarget{
+		RefID:  "A",
+		Target: channel.UUID,
+		Type:   "timeseries",
+	}
+
+	graph := grafana.Panel{
+		Type:    "timeseries",
+		Title:   name,
+		Targets: []grafana.PanelTarget{target},
+		FieldConfig: &grafana.PanelFieldConfig{
+			Defaults:  grafana.PanelFieldDefaults{Unit: unit},
+			Overrides: []interface{}{},
+		},
+	}
+
+	switch unit {
+	case "watt":
+		consumption := target
+		consumption.Payload = map[string]string{
+			"group":   "day",
+			"options": "consumption",
+		}
+
+		bars := grafana.Panel{
+			Type:     "timeseries",
+			Title:    name + " daily consumption",
+			TimeFrom: "30d",
+			Targets:  []grafana.PanelTarget{consumption},
+			FieldConfig: &grafana.PanelFieldConfig{
+				Defaults: grafana.PanelFieldDefaults{
+					Unit: "watth",
+					Custom: map[string]interface{}{
+						"drawStyle":   "bars",
+						"fillOpacity": 80,
+					},
+				},
+				Overrides: []interface{}{},
+			},
+		}
+
+		return []grafana.Panel{graph, bars}
+
+	case "celsius":
+		graph.Type = "gauge"
+		graph.Options = map[string]interface{}{
+			"reduceOptions": map[string]interface{}{
+				"calcs":  []string{"lastNotNull"},
+				"fields": "",
+				"values": false,
+			},
+		}
+	}
+
+	return []grafana.Panel{graph}
+}
+
+// pushDashboard creates or updates the dashboard using Grafana's HTTP API.
+// Credentials are given either as API token or as part of the url.
+func pushDashboard(grafanaURL, token string, dashboard grafana.Dashboard) (string, error) {
+	payload, err := json.Marshal(grafana.DashboardRequest{
+		Dashboard: dashboard,
+		Overwrite: true,
+		Message:   "generated by gravo",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	url := strings.TrimSuffix(grafanaURL, "/") + "/api/dashboards/db"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := http.Client{Timeout: *apiTimeout}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("grafana api: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var res struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+
+	return res.URL, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// downsamplers are the supported values of the downsample payload field
+var downsamplers = []string{"lttb", "minmax", "avg"}
+
+// downsample reduces data to at most points non-null tuples using the
+// given algorithm. Null tuples are retained to keep gaps visible.
+func downsample(data []volkszaehler.Tuple, algorithm string, points int) ([]volkszaehler.Tuple, error) {
+	switch algorithm {
+	case "", "none":
+		return data, nil
+	case "lttb", "minmax", "avg":
+	default:
+		return data, fmt.Errorf("invalid downsample algorithm: %s", algorithm)
+	}
+
+	var values, nulls []volkszaehler.Tuple
+	for _, tuple := range data {
+		if tuple.Null {
+			nulls = append(nulls, tuple)
+		} else {
+			values = append(values, tuple)
+		}
+	}
+
+	if points <= 0 || len(values) <= points {
+		return data, nil
+	}
+
+	switch algorithm {
+	case "lttb":
+		values = downsampleLTTB(values, points)
+	case "minmax":
+		values = downsampleMinMax(values, points)
+	case "avg":
+		values = downsampleAvg(values, points)
+	}
+
+	if len(nulls) == 0 {
+		return values, nil
+	}
+
+	res := append(values, nulls...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Timestamp < res[j].Timestamp
+	})
+
+	return res, nil
+}
+
+// downsampleLTTB selects points using the Largest-Triangle-Three-Buckets
+// algorithm which preserves the visual shape of the series
+// https://skemman.is/bitstream/1946/15343/3/SS_MSthesis.pdf
+func downsampleLTTB(data []volkszaehler.Tuple, points int) []volkszaehler.Tuple {
+	if points < 3 {
+		points = 3
+	}
+
+	res := make([]volkszaehler.Tuple, 0, points)
+	res = append(res, data[0])
+
+	// buckets between first and last point
+	size := float64(len(data)-2) / float64(points-2)
+
+	var a int
+	for i := 0; i < points-2; i++ {
+		// average of next bucket
+		nextStart := int(float64(i+1)*size) + 1
+		nextEnd := int(float64(i+2)*size) + 1
+		if nextEnd > len(data) {
+			nextEnd = len(data)
+		}
+
+		var avgX, avgY float64
+		for _, tuple := range data[nextStart:nextEnd] {
+			avgX += float64(tuple.Timestamp)
+			avgY += tuple.Value
+		}
+		avgX /= float64(nextEnd - nextStart)
+		avgY /= float64(nextEnd - nextStart)
+
+		// point of current bucket with largest triangle
+		start := int(float64(i)*size) + 1
+		end := nextStart
+
+		ax, ay := float64(data[a].Timestamp), data[a].Value
+		maxArea, next := -1.0, start
+
+		for j := start; j < end; j++ {
+			area := math.Abs((ax-avgX)*(data[j].Value-ay) - (ax-float64(data[j].Timestamp))*(avgY-ay))
+			if area > maxArea {
+				maxArea, next = area, j
+			}
+		}
+
+		res = append(res, data[next])
+		a = next
+	}
+
+	return append(res, data[len(data)-1])
+}
+
+// timeBuckets splits data into n buckets of equal duration. Empty buckets
+// are omitted.
+func timeBuckets(data []volkszaehler.Tuple, n int) [][]volkszaehler.Tuple {
+	first, last := data[0].Timestamp, data[len(data)-1].Timestamp
+	width := float64(last-first+1) / float64(n)
+
+	var res [][]volkszaehler.Tuple
+	var bucket []volkszaehler.Tuple
+	current := -1
+
+	for i, tuple := range data {
+		idx := int(float64(tuple.Timestamp-first) / width)
+		if idx != current && bucket != nil {
+			res = append(res, bucket)
+			bucket = nil
+		}
+
+		current = idx
+		bucket = append(bucket, data[i])
+	}
+
+	return append(res, bucket)
+}
+
+// downsampleMinMax keeps the minimum and maximum of each bucket in time
+// order which preserves peaks
+func downsampleMinMax(data []volkszaehler.Tuple, points int) []volkszaehler.Tuple {
+	n := points / 2
+	if n < 1 {
+		n = 1
+	}
+
+	res := make([]volkszaehler.Tuple, 0, points)
+
+	for _, bucket := range timeBuckets(data, n) {
+		min, max := bucket[0], bucket[0]
+		for _, tuple := range bucket[1:] {
+			if tuple.Value < min.Value {
+				min = tuple
+			}
+			if tuple.Value > max.Value {
+				max = tuple
+			}
+		}
+
+		switch {
+		case min.Timestamp == max.Timestamp:
+			res = append(res, min)
+		case min.Timestamp < max.Timestamp:
+			res = append(res, min, max)
+		default:
+			res = append(res, max, min)
+		}
+	}
+
+	return res
+}
+
+// downsampleAvg averages each bucket. The timestamp of the first tuple in
+// the bucket is used.
+func downsampleAvg(data []volkszaehler.Tuple, points int) []volkszaehler.Tuple {
+	res := make([]volkszaehler.Tuple, 0, points)
+
+	for _, bucket := range timeBuckets(data, points) {
+		tuple := volkszaehler.Tuple{Timestamp: bucket[0].Timestamp}
+
+		for _, t := range bucket {
+			tuple.Value += t.Value
+			tuple.Count += t.Count
+		}
+		tuple.Value /= float64(len(bucket))
+
+		res = append(res, tuple)
+	}
+
+	return res
+}
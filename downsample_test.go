@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// series creates tuples with timestamps 0, 1, 2, ..., NaN values are null
+func series(values ...float64) []volkszaehler.Tuple {
+	var res []volkszaehler.Tuple
+	for i, v := range values {
+		tuple := volkszaehler.Tuple{Timestamp: int64(i), Value: v, Count: 1}
+		if math.IsNaN(v) {
+			tuple = volkszaehler.Tuple{Timestamp: int64(i), Null: true}
+		}
+		res = append(res, tuple)
+	}
+	return res
+}
+
+// timestamps returns the tuple timestamps
+func timestamps(data []volkszaehler.Tuple) []int64 {
+	res := make([]int64, 0, len(data))
+	for _, tuple := range data {
+		res = append(res, tuple.Timestamp)
+	}
+	return res
+}
+
+func equalInts(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDownsample(t *testing.T) {
+	nan := math.NaN()
+	spike := series(0, 0, 0, 0, 0, 100, 0, 0, 0, 0)
+	values := series(3, 1, 4, 1, 5, 9, 2, 6, 5, 3)
+
+	tc := []struct {
+		algorithm  string
+		data       []volkszaehler.Tuple
+		points     int
+		timestamps []int64
+	}{
+		{"", values, 2, timestamps(values)},
+		{"none", values, 2, timestamps(values)},
+		{"lttb", values, 0, timestamps(values)},
+		{"lttb", values, 10, timestamps(values)},
+		// first, largest triangle and last point
+		{"lttb", spike, 3, []int64{0, 5, 9}},
+		{"lttb", spike, 2, []int64{0, 5, 9}},
+		{"lttb", spike, 1, []int64{0, 5, 9}},
+		{"lttb", spike, 5, []int64{0, 2, 5, 6, 9}},
+		// min and max per bucket in time order
+		{"minmax", values, 4, []int64{1, 4, 5, 6}},
+		{"minmax", values, 1, []int64{1, 5}},
+		{"minmax", series(1, 1, 1, 1), 2, []int64{0}},
+		{"avg", values, 2, []int64{0, 5}},
+		{"avg", values, 3, []int64{0, 4, 7}},
+		// nulls are kept
+		{"lttb", series(0, nan, 0, 0, 100, 0, nan, 0), 3, []int64{0, 1, 4, 6, 7}},
+		{"avg", series(nan, 1, 2, 3, 4), 2, []int64{0, 1, 3}},
+		{"avg", series(nan, nan), 1, []int64{0, 1}},
+	}
+
+	for _, c := range tc {
+		res, err := downsample(c.data, c.algorithm, c.points)
+		if err != nil {
+			t.Errorf("%s(%d): unexpected error: %v", c.algorithm, c.points, err)
+			continue
+		}
+
+		if ts := timestamps(res); !equalInts(ts, c.timestamps) {
+			t.Errorf("%s(%d) %v: expected %v, got %v", c.algorithm, c.points, c.data, c.timestamps, ts)
+		}
+	}
+
+	if _, err := downsample(values, "foo", 2); err == nil {
+		t.Error("expected invalid algorithm error")
+	}
+}
+
+func TestDownsampleValues(t *testing.T) {
+	values := series(3, 1, 4, 1, 5, 9, 2, 6, 5, 3)
+
+	res, err := downsample(values, "minmax", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []float64{1, 5, 9, 2} {
+		if res[i].Value != expected {
+			t.Errorf("minmax %d: expected %v, got %v", i, expected, res[i].Value)
+		}
+	}
+
+	res, err = downsample(values, "avg", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []float64{2.8, 5} {
+		if math.Abs(res[i].Value-expected) > 1e-9 || res[i].Count != 5 {
+			t.Errorf("avg %d: expected %v with count 5, got %+v", i, expected, res[i])
+		}
+	}
+}
+
+func TestTimeBuckets(t *testing.T) {
+	tc := []struct {
+		timestamps []int64
+		n          int
+		sizes      []int
+	}{
+		{[]int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, 2, []int{5, 5}},
+		{[]int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, 3, []int{4, 3, 3}},
+		{[]int64{0, 1, 2, 3}, 10, []int{1, 1, 1, 1}},
+		// empty buckets are omitted
+		{[]int64{0, 1, 2, 100}, 4, []int{3, 1}},
+		{[]int64{5}, 3, []int{1}},
+	}
+
+	for _, c := range tc {
+		var data []volkszaehler.Tuple
+		for _, ts := range c.timestamps {
+			data = append(data, volkszaehler.Tuple{Timestamp: ts})
+		}
+
+		buckets := timeBuckets(data, c.n)
+
+		sizes := make([]int, 0, len(buckets))
+		for _, bucket := range buckets {
+			sizes = append(sizes, len(bucket))
+		}
+
+		if len(sizes) != len(c.sizes) {
+			t.Errorf("%v/%d: expected %v, got %v", c.timestamps, c.n, c.sizes, sizes)
+			continue
+		}
+
+		for i := range sizes {
+			if sizes[i] != c.sizes[i] {
+				t.Errorf("%v/%d: expected %v, got %v", c.timestamps, c.n, c.sizes, sizes)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andig/gravo/grafana"
+)
+
+// export formats
+const (
+	exportCSV    = "csv"
+	exportNDJSON = "ndjson"
+)
+
+// exportOptions controls formatting of exported data
+type exportOptions struct {
+	format       string
+	delimiter    rune
+	decimalComma bool
+	location     *time.Location
+}
+
+// exportRow is a single NDJSON export record
+type exportRow struct {
+	Time      string   `json:"time"`
+	Timestamp int64    `json:"timestamp"`
+	Name      string   `json:"name"`
+	UUID      string   `json:"uuid"`
+	Value     *float64 `json:"value"`
+	Count     int      `json:"count,omitempty"`
+}
+
+// newExportOptions validates the format, delimiter, decimal separator and
+// time zone parameters
+func newExportOptions(format, delimiter, decimal, tz string) (exportOptions, error) {
+	opts := exportOptions{
+		format:    strings.ToLower(format),
+		delimiter: ',',
+		location:  time.Local,
+	}
+
+	switch opts.format {
+	case "":
+		opts.format = exportCSV
+	case exportCSV, exportNDJSON:
+	default:
+		return opts, fmt.Errorf("unsupported format: %s", opts.format)
+	}
+
+	if delimiter != "" {
+		switch delimiter {
+		case "tab", `\t`:
+			delimiter = "\t"
+		case "semicolon":
+			delimiter = ";"
+		}
+
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' {
+			return opts, fmt.Errorf("invalid delimiter: %s", delimiter)
+		}
+		opts.delimiter = r
+	}
+
+	switch decimal = strings.ToLower(decimal); decimal {
+	case "", ".", "point":
+	case ",", "comma":
+		opts.decimalComma = true
+	default:
+		return opts, fmt.Errorf("invalid decimal separator: %s", decimal)
+	}
+
+	// decimal comma requires a different field delimiter to avoid quoting
+	if opts.decimalComma && delimiter == "" {
+		opts.delimiter = ';'
+	}
+
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return opts, err
+		}
+		opts.location = loc
+	}
+
+	return opts, nil
+}
+
+// parseExportTime parses RFC3339 timestamps, dates, date and time without
+// zone in the given location or unix timestamps in milliseconds. Compact
+// dates like 20240101 take precedence over timestamps.
+func parseExportTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02", "20060102"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	// timestamps in milliseconds have at least 10 digits since 1970-04-26
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil && len(strings.TrimPrefix(s, "-")) >= 10 {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time: %s", s)
+}
+
+// exportRequest creates the query request for the export parameters.
+// Targets are channel uuids or title paths.
+func (server *Server) exportRequest(r *http.Request, loc *time.Location) (grafana.QueryRequest, error) {
+	qr := grafana.QueryRequest{
+		Range: grafana.Range{
+			From: time.Now().Add(-24 * time.Hour),
+			To:   time.Now(),
+		},
+	}
+
+	if from := r.FormValue("from"); from != "" {
+		t, err := parseExportTime(from, loc)
+		if err != nil {
+			return qr, err
+		}
+		qr.Range.From = t
+	}
+
+	if to := r.FormValue("to"); to != "" {
+		t, err := parseExportTime(to, loc)
+		if err != nil {
+			return qr, err
+		}
+		qr.Range.To = t
+	}
+
+	if s := r.FormValue("tuples"); s != "" {
+		tuples, err := strconv.Atoi(s)
+		if err != nil {
+			return qr, fmt.Errorf("invalid tuples: %s", s)
+		}
+		qr.MaxDataPoints = tuples
+	}
+
+	targets, names := r.Form["target"], r.Form["name"]
+	if len(names) > 0 && len(names) != len(targets) {
+		return qr, fmt.Errorf("expected one name per target")
+	}
+
+	for i, target := range targets {
+		t := grafana.Target{
+			Target: server.resolveUUID(target),
+			RefID:  target,
+		}
+
+		t.Data.Group = r.FormValue("group")
+		t.Data.Options = r.FormValue("options")
+		if len(names) > 0 {
+			t.Data.Name = names[i]
+		}
+
+		qr.Targets = append(qr.Targets, t)
+	}
+
+	if len(qr.Targets) == 0 {
+		return qr, fmt.Errorf("missing target")
+	}
+
+	return qr, nil
+}
+
+func (server *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := newExportOptions(r.FormValue("format"), r.FormValue("delimiter"), r.FormValue("decimal"), r.FormValue("tz"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	qr, err := server.exportRequest(r, opts.location)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if opts.format == exportNDJSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export.%s"`, opts.format))
+
+	ew, err := newExportWriter(w, opts)
+	if err != nil {
+		log.Printf("export failed: %v", err)
+		return
+	}
+
+	// series are written as soon as their query completes
+	for qres := range server.streamQuery(qr) {
+		if qres.Error != nil {
+			log.Printf("export failed: %v: %v", qres.Target, qres.Error)
+			continue
+		}
+
+		if err == nil {
+			if err = ew.write(qres); err != nil {
+				log.Printf("export failed: %v", err)
+			}
+		}
+	}
+}
+
+// exportWriter writes query results as CSV or NDJSON series by series, one
+// row per data point
+type exportWriter struct {
+	w    io.Writer
+	opts exportOptions
+	csv  *csv.Writer
+	enc  *json.Encoder
+}
+
+// newExportWriter creates an export writer and writes the CSV header
+func newExportWriter(w io.Writer, opts exportOptions) (*exportWriter, error) {
+	ew := &exportWriter{w: w, opts: opts}
+
+	if opts.format == exportNDJSON {
+		ew.enc = json.NewEncoder(w)
+		return ew, nil
+	}
+
+	ew.csv = csv.NewWriter(w)
+	ew.csv.Comma = opts.delimiter
+
+	if err := ew.csv.Write([]string{"time", "name", "uuid", "value"}); err != nil {
+		return nil, err
+	}
+
+	return ew, nil
+}
+
+// write writes the series and flushes it to the client
+func (ew *exportWriter) write(qres grafana.QueryResponse) error {
+	var err error
+	if ew.enc != nil {
+		err = ew.writeNDJSON(qres)
+	} else {
+		err = ew.writeCSV(qres)
+	}
+
+	flush(ew.w)
+
+	return err
+}
+
+func (ew *exportWriter) writeCSV(qres grafana.QueryResponse) error {
+	name := fmt.Sprintf("%v", qres.Target)
+
+	for _, dp := range qres.Datapoints {
+		var value string
+		if !dp.Null {
+			value = strconv.FormatFloat(dp.Value, 'f', -1, 64)
+			if ew.opts.decimalComma {
+				value = strings.Replace(value, ".", ",", 1)
+			}
+		}
+
+		record := []string{
+			exportTime(dp.Timestamp, ew.opts.location),
+			name,
+			qres.Labels["uuid"],
+			value,
+		}
+
+		if err := ew.csv.Write(record); err != nil {
+			return err
+		}
+	}
+
+	ew.csv.Flush()
+
+	return ew.csv.Error()
+}
+
+func (ew *exportWriter) writeNDJSON(qres grafana.QueryResponse) error {
+	name := fmt.Sprintf("%v", qres.Target)
+
+	for _, dp := range qres.Datapoints {
+		row := exportRow{
+			Time:      exportTime(dp.Timestamp, ew.opts.location),
+			Timestamp: dp.Timestamp,
+			Name:      name,
+			UUID:      qres.Labels["uuid"],
+			Count:     dp.Count,
+		}
+
+		if !dp.Null {
+			value := dp.Value
+			row.Value = &value
+		}
+
+		if err := ew.enc.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeExport writes query results as CSV or NDJSON
+func writeExport(w io.Writer, results []grafana.QueryResponse, opts exportOptions) error {
+	ew, err := newExportWriter(w, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, qres := range results {
+		if err := ew.write(qres); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportTime formats a timestamp in milliseconds as RFC3339 in loc
+func exportTime(ts int64, loc *time.Location) string {
+	return time.Unix(0, ts*int64(time.Millisecond)).In(loc).Format(time.RFC3339)
+}
+
+// flush sends buffered data to the client if w supports flushing
+func flush(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
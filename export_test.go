@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andig/gravo/volkszaehler"
+)
+
+func TestExportRequestNames(t *testing.T) {
+	server := newServer(&fakeAPI{
+		entities: []volkszaehler.Entity{
+			{UUID: "c1", Type: "power", Title: "House/Grid"},
+			{UUID: "c2", Type: "power", Title: "Garage"},
+		},
+	}, 0, nil)
+	defer server.Close()
+
+	tc := []struct {
+		query string
+		names []string
+		err   bool
+	}{
+		{"target=House/Grid&target=Garage", []string{"", ""}, false},
+		{"target=House/Grid&name=grid", []string{"grid"}, false},
+		{"target=House/Grid&name=grid&target=Garage&name=garage", []string{"grid", "garage"}, false},
+		{"target=House/Grid&target=Garage&name=grid", nil, true},
+		{"target=House/Grid&name=grid&name=garage", nil, true},
+		{"name=grid", nil, true},
+	}
+
+	for _, c := range tc {
+		r, err := http.NewRequest(http.MethodGet, "/export?"+c.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+
+		qr, err := server.exportRequest(r, time.UTC)
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected error", c.query)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.query, err)
+			continue
+		}
+
+		if len(qr.Targets) != len(c.names) {
+			t.Errorf("%s: expected %d targets, got %d", c.query, len(c.names), len(qr.Targets))
+			continue
+		}
+
+		for i, target := range qr.Targets {
+			if target.Data.Name != c.names[i] {
+				t.Errorf("%s: expected name %q, got %q", c.query, c.names[i], target.Data.Name)
+			}
+		}
+	}
+}
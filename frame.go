@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/andig/gravo/grafana"
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// units maps volkszaehler entity types to Grafana units
+var units = map[string]string{
+	"power":          "watt",
+	"powersensor":    "watt",
+	"electric meter": "watt",
+	"heat":           "watt",
+	"temperature":    "celsius",
+	"humidity":       "humidity",
+	"pressure":       "pressurehpa",
+	"voltage":        "volt",
+	"current":        "amp",
+	"frequency":      "hertz",
+}
+
+// entityUnit returns the Grafana unit of the entity's data depending on
+// query options
+func entityUnit(entity volkszaehler.Entity, options string) string {
+	if strings.Contains(options, "raw") {
+		return ""
+	}
+
+	unit := units[entity.Type]
+	if unit == "watt" && strings.Contains(options, "consumption") {
+		unit = "watth"
+	}
+
+	return unit
+}
+
+// addSeriesMetadata adds unit and labels to the query response
+func (server *Server) addSeriesMetadata(qres *grafana.QueryResponse, target grafana.Target) {
+	qres.RefID = target.RefID
+	qres.Decimals = target.Data.Decimals
+
+	qres.Labels = map[string]string{
+		"uuid": target.Target,
+	}
+
+	if group := strings.ToLower(target.Data.Group); group != "" {
+		qres.Labels["group"] = group
+	}
+
+	if entity, ok := server.entity(target.Target); ok {
+		qres.Labels["title"] = entity.Title
+		qres.Labels["type"] = entity.Type
+		qres.Unit = entityUnit(entity, strings.ToLower(target.Data.Options))
+	}
+
+	if target.Data.Unit != "" {
+		qres.Unit = target.Data.Unit
+	}
+}
+
+// queryResponses converts query results into the requested response
+// format per target
+func queryResponses(qr grafana.QueryRequest, results []grafana.QueryResponse) []interface{} {
+	res := make([]interface{}, len(results))
+
+	for idx, qres := range results {
+		if strings.EqualFold(qr.Format, grafana.FormatFrames) || strings.EqualFold(qr.Targets[idx].Data.Format, grafana.FormatFrames) {
+			res[idx] = qres.DataFrame()
+		} else {
+			res[idx] = qres
+		}
+	}
+
+	return res
+}
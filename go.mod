@@ -1,3 +1,13 @@
 module github.com/andig/gravo
 
 go 1.13
+
+require (
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/golang/snappy v0.0.4
+	github.com/gorilla/websocket v1.4.2
+	github.com/grafana/grafana-plugin-sdk-go v0.94.0
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	go.etcd.io/bbolt v1.3.6
+	google.golang.org/protobuf v1.26.0
+)
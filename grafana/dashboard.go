@@ -0,0 +1,70 @@
+package grafana
+
+// Dashboard is the subset of the Grafana dashboard model used for
+// generating dashboards
+// https://grafana.com/docs/grafana/latest/dashboards/json-model/
+type Dashboard struct {
+	ID            *int64        `json:"id"`
+	UID           string        `json:"uid,omitempty"`
+	Title         string        `json:"title"`
+	Tags          []string      `json:"tags"`
+	Editable      bool          `json:"editable"`
+	SchemaVersion int           `json:"schemaVersion"`
+	Time          RelativeRange `json:"time"`
+	Refresh       string        `json:"refresh,omitempty"`
+	Panels        []Panel       `json:"panels"`
+}
+
+// DashboardRequest is the payload of Grafana's create/update dashboard API
+// https://grafana.com/docs/grafana/latest/http_api/dashboard/
+type DashboardRequest struct {
+	Dashboard Dashboard `json:"dashboard"`
+	FolderID  int64     `json:"folderId"`
+	Overwrite bool      `json:"overwrite"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// Panel is a dashboard panel or row
+type Panel struct {
+	ID          int                    `json:"id"`
+	Type        string                 `json:"type"`
+	Title       string                 `json:"title"`
+	Datasource  string                 `json:"datasource,omitempty"`
+	GridPos     GridPos                `json:"gridPos"`
+	TimeFrom    string                 `json:"timeFrom,omitempty"`
+	Targets     []PanelTarget          `json:"targets,omitempty"`
+	FieldConfig *PanelFieldConfig      `json:"fieldConfig,omitempty"`
+	Options     map[string]interface{} `json:"options,omitempty"`
+	// rows only
+	Collapsed bool    `json:"collapsed,omitempty"`
+	Panels    []Panel `json:"panels,omitempty"`
+}
+
+// GridPos is the panel position in the 24 column dashboard grid
+type GridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// PanelTarget is a panel query as stored by the JSON datasource
+type PanelTarget struct {
+	RefID   string            `json:"refId"`
+	Target  string            `json:"target"`
+	Type    string            `json:"type"`
+	Payload map[string]string `json:"payload,omitempty"`
+}
+
+// PanelFieldConfig holds the panel's field display options
+type PanelFieldConfig struct {
+	Defaults  PanelFieldDefaults `json:"defaults"`
+	Overrides []interface{}      `json:"overrides"`
+}
+
+// PanelFieldDefaults are the display options applied to all fields
+type PanelFieldDefaults struct {
+	Unit     string                 `json:"unit,omitempty"`
+	Decimals *int                   `json:"decimals,omitempty"`
+	Custom   map[string]interface{} `json:"custom,omitempty"`
+}
@@ -2,6 +2,9 @@ package grafana
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -74,26 +77,123 @@ type QueryRequest struct {
 	IntervalMs    int64         `json:"intervalMs"`
 	Targets       []Target      `json:"targets"`
 	AdhocFilters  []Filter      `json:"adhocFilters"`
-	Format        string        `json:"json"`
+	Format        string        `json:"format"`
 	MaxDataPoints int           `json:"maxDataPoints"`
+	ScopedVars    ScopedVars    `json:"scopedVars"`
 }
 
+// ScopedVars contains the template variables of the requesting dashboard
+type ScopedVars map[string]ScopedVar
+
+// ScopedVar is a single template variable value
+type ScopedVar struct {
+	Text  interface{} `json:"text"`
+	Value interface{} `json:"value"`
+}
+
+// FormatFrames selects the data frame response format in QueryRequest or TargetData
+const FormatFrames = "frames"
+
 // QueryResponse contains information to render query result.
 type QueryResponse struct {
 	Target     interface{}     `json:"target"`
 	Datapoints []ResponseTuple `json:"datapoints"`
+
+	// series metadata used for data frame responses
+	RefID    string            `json:"-"`
+	Unit     string            `json:"-"`
+	Decimals *int              `json:"-"`
+	Labels   map[string]string `json:"-"`
+
+	// Error is the query error reported to Grafana
+	Error error `json:"-"`
+}
+
+// DataFrame converts the query response into data frame format
+func (r *QueryResponse) DataFrame() DataFrame {
+	name := fmt.Sprintf("%v", r.Target)
+
+	times := make([]int64, 0, len(r.Datapoints))
+	values := make([]*float64, 0, len(r.Datapoints))
+
+	for _, dp := range r.Datapoints {
+		times = append(times, dp.Timestamp)
+
+		if dp.Null {
+			values = append(values, nil)
+		} else {
+			value := dp.Value
+			values = append(values, &value)
+		}
+	}
+
+	return DataFrame{
+		Name:  name,
+		RefID: r.RefID,
+		Fields: []Field{
+			{
+				Name:   "time",
+				Type:   "time",
+				Values: times,
+			},
+			{
+				Name:   "value",
+				Type:   "number",
+				Values: values,
+				Labels: r.Labels,
+				Config: &FieldConfig{
+					DisplayNameFromDS: name,
+					Unit:              r.Unit,
+					Decimals:          r.Decimals,
+				},
+			},
+		},
+	}
+}
+
+// DataFrame is a Grafana data frame in JSON representation
+// https://grafana.com/docs/grafana/latest/developers/plugins/data-frames/
+type DataFrame struct {
+	Name   string  `json:"name"`
+	RefID  string  `json:"refId,omitempty"`
+	Fields []Field `json:"fields"`
+}
+
+// Field is a typed data frame column
+type Field struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	Values interface{}       `json:"values"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Config *FieldConfig      `json:"config,omitempty"`
+}
+
+// FieldConfig describes how to display a field
+type FieldConfig struct {
+	DisplayNameFromDS string `json:"displayNameFromDS,omitempty"`
+	Unit              string `json:"unit,omitempty"`
+	Decimals          *int   `json:"decimals,omitempty"`
 }
 
 // ResponseTuple is a single data point as Grafana understands
 type ResponseTuple struct {
-	Value     float32
+	Value     float64
 	Timestamp int64
+	// Null is encoded as null value
+	Null bool
+	// Count is the number of aggregated readings, it is not encoded
+	Count int
 }
 
 // MarshalJSON converts ResponseTuple to json
 func (t *ResponseTuple) MarshalJSON() ([]byte, error) {
+	var value interface{} = t.Value
+	if t.Null {
+		value = nil
+	}
+
 	a := []interface{}{
-		t.Value,
+		value,
 		t.Timestamp,
 	}
 
@@ -116,6 +216,85 @@ type TargetData struct {
 	Name    string `json:"name"`
 	Period  string `json:"period"`
 	Tuples  int64  `json:"tuples"`
+	// Aggregate lists the functions applied to the series, e.g. movingAverage(15m)
+	Aggregate string `json:"aggregate"`
+	// Downsample selects the gravo-side downsampling algorithm
+	Downsample string `json:"downsample"`
+	// data frame response options
+	Format   string `json:"format"`
+	Unit     string `json:"unit"`
+	Decimals *int   `json:"decimals"`
+	// Stream enables Grafana Live updates in backend plugin mode
+	Stream bool `json:"stream"`
+}
+
+// payloadNumber is a numeric payload field given as number or string as
+// sent by payload editor inputs. Empty strings and null are treated as unset.
+type payloadNumber string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (n *payloadNumber) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		s = ""
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+
+	*n = payloadNumber(strings.TrimSpace(s))
+	return nil
+}
+
+// int returns the field value and false if unset
+func (n payloadNumber) int() (int64, bool, error) {
+	if n == "" {
+		return 0, false, nil
+	}
+
+	i, err := strconv.ParseInt(string(n), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid number: %s", string(n))
+	}
+
+	return i, true, nil
+}
+
+// UnmarshalJSON converts target payload into TargetData struct. Numeric
+// fields may be given as number or string as sent by payload editor inputs.
+func (d *TargetData) UnmarshalJSON(b []byte) error {
+	type targetData TargetData // prevent recursion
+	var td struct {
+		targetData
+		Tuples   payloadNumber `json:"tuples"`
+		Decimals payloadNumber `json:"decimals"`
+	}
+
+	if err := json.Unmarshal(b, &td); err != nil {
+		return err
+	}
+
+	*d = TargetData(td.targetData)
+
+	tuples, _, err := td.Tuples.int()
+	if err != nil {
+		return fmt.Errorf("tuples: %v", err)
+	}
+	d.Tuples = tuples
+
+	decimals, ok, err := td.Decimals.int()
+	if err != nil {
+		return fmt.Errorf("decimals: %v", err)
+	}
+	if ok {
+		dec := int(decimals)
+		d.Decimals = &dec
+	}
+
+	return nil
 }
 
 // Filter is a compontent of adhoc filters
@@ -136,3 +315,73 @@ type SearchResponse struct {
 	Text string `json:"text"`
 	UUID string `json:"value"`
 }
+
+// VariableRequest encodes the information provided by Grafana in /variable.
+// https://github.com/simPod/grafana-json-datasource#variable-variable
+type VariableRequest struct {
+	Payload  json.RawMessage `json:"payload"`
+	Range    Range           `json:"range"`
+	RangeRaw RelativeRange   `json:"rangeRaw"`
+}
+
+// Target returns the variable query which is either the payload itself
+// or the payload's target attribute.
+func (r *VariableRequest) Target() string {
+	var target string
+	if err := json.Unmarshal(r.Payload, &target); err == nil {
+		return target
+	}
+
+	var payload struct {
+		Target string `json:"target"`
+	}
+	_ = json.Unmarshal(r.Payload, &payload)
+
+	return payload.Target
+}
+
+// VariableResponse contains a single template variable value
+type VariableResponse struct {
+	Text  string `json:"__text"`
+	Value string `json:"__value"`
+}
+
+// MetricsRequest encodes the information provided by Grafana in /metrics.
+// https://github.com/simPod/grafana-json-datasource#metrics-metrics
+type MetricsRequest struct {
+	Metric  string                 `json:"metric"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// MetricsResponse describes a metric and its payload fields
+type MetricsResponse struct {
+	Label    string          `json:"label"`
+	Value    string          `json:"value"`
+	Payloads []MetricPayload `json:"payloads"`
+}
+
+// MetricPayload describes a payload field shown by the query editor
+type MetricPayload struct {
+	Label        string                `json:"label"`
+	Name         string                `json:"name"`
+	Type         string                `json:"type"`
+	Placeholder  string                `json:"placeholder,omitempty"`
+	ReloadMetric bool                  `json:"reloadMetric,omitempty"`
+	Width        int                   `json:"width,omitempty"`
+	Options      []MetricPayloadOption `json:"options,omitempty"`
+}
+
+// MetricPayloadOption is a valid value of a select payload field
+type MetricPayloadOption struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// MetricPayloadOptionsRequest encodes the information provided by Grafana
+// in /metric-payload-options.
+// https://github.com/simPod/grafana-json-datasource#metric-payload-options-metric-payload-options
+type MetricPayloadOptionsRequest struct {
+	Metric  string                 `json:"metric"`
+	Payload map[string]interface{} `json:"payload"`
+	Name    string                 `json:"name"`
+}
@@ -0,0 +1,33 @@
+package grafana
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResponseTupleMarshalJSON(t *testing.T) {
+	tc := []struct {
+		tuple    ResponseTuple
+		expected string
+	}{
+		{ResponseTuple{Timestamp: 1600000000000, Value: 12.5, Count: 3}, `[12.5,1600000000000]`},
+		{ResponseTuple{Timestamp: 1600000000000, Value: 123456.789}, `[123456.789,1600000000000]`},
+		{ResponseTuple{Timestamp: 1600000000000, Value: 0.1}, `[0.1,1600000000000]`},
+		{ResponseTuple{Timestamp: 1600000000000, Value: -1e-7}, `[-1e-7,1600000000000]`},
+		{ResponseTuple{Timestamp: 1600000000000}, `[0,1600000000000]`},
+		{ResponseTuple{Timestamp: 1600000000000, Null: true}, `[null,1600000000000]`},
+		{ResponseTuple{Timestamp: 1600000000000, Value: 1, Null: true}, `[null,1600000000000]`},
+	}
+
+	for _, c := range tc {
+		b, err := json.Marshal(&c.tuple)
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", c.tuple, err)
+			continue
+		}
+
+		if string(b) != c.expected {
+			t.Errorf("%+v: expected %s, got %s", c.tuple, c.expected, string(b))
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andig/gravo/grafana"
+	"github.com/andig/gravo/graphite"
+)
+
+var graphiteSegmentRE = regexp.MustCompile(`[.\s]+`)
+
+// graphitePath converts an entity title path into dotted Graphite path
+// segments
+func graphitePath(title string) []string {
+	segments := strings.Split(title, "/")
+	for idx, segment := range segments {
+		segments[idx] = graphiteSegmentRE.ReplaceAllString(segment, "_")
+	}
+
+	return segments
+}
+
+// graphiteGlob converts a Graphite path segment pattern supporting *, ?,
+// [...] and {a,b} into a regular expression
+func graphiteGlob(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern: %s", pattern)
+			}
+			re.WriteString(pattern[i : i+end+1])
+			i += end
+		case '{':
+			end := strings.IndexByte(pattern[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern: %s", pattern)
+			}
+			alternatives := strings.Split(pattern[i+1:i+end], ",")
+			for idx, alt := range alternatives {
+				alternatives[idx] = regexp.QuoteMeta(alt)
+			}
+			re.WriteString("(?:" + strings.Join(alternatives, "|") + ")")
+			i += end
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	re.WriteString("$")
+
+	return regexp.Compile(re.String())
+}
+
+// graphiteMatcher matches the leading segments of a path against query
+type graphiteMatcher []*regexp.Regexp
+
+func newGraphiteMatcher(query string) (graphiteMatcher, error) {
+	var m graphiteMatcher
+
+	for _, pattern := range graphiteSplit(query, '.') {
+		re, err := graphiteGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, re)
+	}
+
+	return m, nil
+}
+
+func (m graphiteMatcher) match(segments []string) bool {
+	if len(segments) < len(m) {
+		return false
+	}
+
+	for idx, re := range m {
+		if !re.MatchString(segments[idx]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// graphiteSplit splits s at sep outside of braces
+func graphiteSplit(s string, sep byte) []string {
+	var res []string
+	var depth, start int
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case sep:
+			if depth == 0 {
+				res = append(res, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(res, s[start:])
+}
+
+func (server *Server) graphiteFindHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("query")
+	if query == "" {
+		query = "*"
+	}
+
+	m, err := newGraphiteMatcher(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := []graphite.Node{}
+	seen := make(map[string]bool)
+
+	for _, entity := range server.getPublicEntites() {
+		segments := graphitePath(entity.Title)
+		if !m.match(segments) {
+			continue
+		}
+
+		id := strings.Join(segments[:len(m)], ".")
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		node := graphite.Node{
+			Text:    segments[len(m)-1],
+			ID:      id,
+			Context: map[string]interface{}{},
+		}
+
+		if len(segments) == len(m) {
+			node.Leaf = 1
+		} else {
+			node.Expandable = 1
+			node.AllowChildren = 1
+		}
+
+		resp = append(resp, node)
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+func (server *Server) graphiteRenderHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if format := r.Form.Get("format"); format != "" && format != "json" {
+		http.Error(w, fmt.Sprintf("unsupported format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	from, err := graphite.ParseTime(r.Form.Get("from"), now)
+	if err == nil && r.Form.Get("from") == "" {
+		from = now.Add(-24 * time.Hour)
+	}
+
+	var until time.Time
+	if err == nil {
+		until, err = graphite.ParseTime(r.Form.Get("until"), now)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	maxDataPoints, _ := strconv.Atoi(r.Form.Get("maxDataPoints"))
+
+	ctx := graphite.Context{
+		From:  from,
+		Until: until,
+		Fetch: func(path string, from, until time.Time) ([]graphite.Series, error) {
+			return server.graphiteFetch(path, from, until, maxDataPoints)
+		},
+	}
+
+	resp := []graphite.Series{}
+	for _, target := range r.Form["target"] {
+		expr, err := graphite.Parse(target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		series, err := graphite.Eval(expr, ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp = append(resp, series...)
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+// graphiteFetch queries the data of all channels matching path
+func (server *Server) graphiteFetch(path string, from, until time.Time, maxDataPoints int) ([]graphite.Series, error) {
+	m, err := newGraphiteMatcher(path)
+	if err != nil {
+		return nil, err
+	}
+
+	qr := grafana.QueryRequest{
+		Range: grafana.Range{
+			From: from,
+			To:   until,
+		},
+		MaxDataPoints: maxDataPoints,
+	}
+
+	res := []graphite.Series{}
+	for _, entity := range server.getPublicEntites() {
+		segments := graphitePath(entity.Title)
+		if len(segments) != len(m) || !m.match(segments) {
+			continue
+		}
+
+		qres := server.queryData(grafana.Target{Target: entity.UUID}, &qr)
+
+		series := graphite.Series{
+			Target:     strings.Join(segments, "."),
+			Datapoints: make([]graphite.Point, 0, len(qres.Datapoints)),
+		}
+
+		for _, dp := range qres.Datapoints {
+			series.Datapoints = append(series.Datapoints, graphite.Point{
+				Value:     dp.Value,
+				Null:      dp.Null,
+				Timestamp: dp.Timestamp / 1e3,
+			})
+		}
+
+		res = append(res, series)
+	}
+
+	return res, nil
+}
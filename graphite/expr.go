@@ -0,0 +1,151 @@
+package graphite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a parsed render target, either a function call, a metric path
+// or a literal argument
+type Expr struct {
+	Func string
+	Args []Expr
+	Path string
+	Str  string
+	Num  float64
+	Kind ExprKind
+}
+
+// ExprKind is the type of expression
+type ExprKind int
+
+// Expression kinds
+const (
+	PathExpr ExprKind = iota
+	CallExpr
+	StringExpr
+	NumberExpr
+)
+
+type parser struct {
+	s   string
+	pos int
+}
+
+// Parse parses a render target like sumSeries(House.*)
+func Parse(target string) (Expr, error) {
+	p := &parser{s: target}
+
+	e, err := p.expr()
+	if err != nil {
+		return e, err
+	}
+
+	p.space()
+	if p.pos < len(p.s) {
+		return e, fmt.Errorf("unexpected %q at %d", p.s[p.pos:], p.pos)
+	}
+
+	return e, nil
+}
+
+func (p *parser) space() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) expr() (Expr, error) {
+	p.space()
+	if p.pos >= len(p.s) {
+		return Expr{}, fmt.Errorf("unexpected end of target")
+	}
+
+	switch c := p.s[p.pos]; {
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(p.s[p.pos+1:], c)
+		if end < 0 {
+			return Expr{}, fmt.Errorf("unterminated string at %d", p.pos)
+		}
+
+		str := p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+
+		return Expr{Kind: StringExpr, Str: str}, nil
+
+	case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.s) && strings.IndexByte("0123456789.-+eE", p.s[p.pos]) >= 0 {
+			p.pos++
+		}
+
+		// numbers may also start metric paths
+		if f, err := strconv.ParseFloat(p.s[start:p.pos], 64); err == nil && p.atArgEnd() {
+			return Expr{Kind: NumberExpr, Num: f}, nil
+		}
+
+		p.pos = start
+	}
+
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c == '{' || c == '[' {
+			depth++
+		} else if c == '}' || c == ']' {
+			depth--
+		} else if depth == 0 && (c == '(' || c == ')' || c == ',') {
+			break
+		}
+		p.pos++
+	}
+
+	name := strings.TrimSpace(p.s[start:p.pos])
+	if name == "" {
+		return Expr{}, fmt.Errorf("missing expression at %d", start)
+	}
+
+	if p.pos < len(p.s) && p.s[p.pos] == '(' {
+		p.pos++
+		call := Expr{Kind: CallExpr, Func: name}
+
+		for {
+			p.space()
+			if p.pos < len(p.s) && p.s[p.pos] == ')' {
+				p.pos++
+				return call, nil
+			}
+
+			arg, err := p.expr()
+			if err != nil {
+				return call, err
+			}
+			call.Args = append(call.Args, arg)
+
+			p.space()
+			if p.pos >= len(p.s) {
+				return call, fmt.Errorf("unterminated call to %s", name)
+			}
+
+			switch p.s[p.pos] {
+			case ',':
+				p.pos++
+			case ')':
+				p.pos++
+				return call, nil
+			default:
+				return call, fmt.Errorf("unexpected %q at %d", p.s[p.pos], p.pos)
+			}
+		}
+	}
+
+	return Expr{Kind: PathExpr, Path: name}, nil
+}
+
+func (p *parser) atArgEnd() bool {
+	p.space()
+	return p.pos >= len(p.s) || p.s[p.pos] == ',' || p.s[p.pos] == ')'
+}
@@ -0,0 +1,80 @@
+package graphite
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	path := func(p string) Expr { return Expr{Kind: PathExpr, Path: p} }
+	str := func(s string) Expr { return Expr{Kind: StringExpr, Str: s} }
+	num := func(n float64) Expr { return Expr{Kind: NumberExpr, Num: n} }
+	call := func(f string, args ...Expr) Expr { return Expr{Kind: CallExpr, Func: f, Args: args} }
+
+	tc := []struct {
+		target   string
+		expected Expr
+	}{
+		{"House.Grid", path("House.Grid")},
+		{" House.* ", path("House.*")},
+		{"House.{Grid,Solar}", path("House.{Grid,Solar}")},
+		{"House.Meter[0-9]", path("House.Meter[0-9]")},
+		{"sumSeries(House.*, Garage)", call("sumSeries", path("House.*"), path("Garage"))},
+		{`alias(scale(House.{Grid,Solar}, -1.5), "Net")`, call("alias", call("scale", path("House.{Grid,Solar}"), num(-1.5)), str("Net"))},
+		{`summarize(House.Grid, '1d', "max", true)`, call("summarize", path("House.Grid"), str("1d"), str("max"), path("true"))},
+		{"scale(House.Grid,1e3)", call("scale", path("House.Grid"), num(1000))},
+		// numbers may start metric paths
+		{"scale(1.Meter, 2)", call("scale", path("1.Meter"), num(2))},
+		{"sumSeries( )", call("sumSeries")},
+		{`alias(a, "b,c)")`, call("alias", path("a"), str("b,c)"))},
+		{"-1", num(-1)},
+	}
+
+	for _, c := range tc {
+		e, err := Parse(c.target)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.target, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(e, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", c.target, c.expected, e)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, target := range []string{
+		"",
+		"  ",
+		"sumSeries(",
+		"sumSeries(a",
+		"sumSeries(a,",
+		"sumSeries(a))",
+		"sumSeries(,a)",
+		`alias(a, "b)`,
+		`alias(a, "b" c)`,
+		"(a)",
+	} {
+		if _, err := Parse(target); err == nil {
+			t.Errorf("%q: expected error", target)
+		}
+	}
+}
+
+func TestExprString(t *testing.T) {
+	for _, target := range []string{
+		"House.Grid",
+		`alias(scale(House.{Grid,Solar},-1.5),"Net")`,
+		`summarize(House.Grid,"1d","max",true)`,
+	} {
+		e, err := Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s := e.String(); s != target {
+			t.Errorf("expected %s, got %s", target, s)
+		}
+	}
+}
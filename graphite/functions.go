@@ -0,0 +1,384 @@
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andig/gravo/interval"
+)
+
+// FetchFunc returns the series of all metrics matching path
+type FetchFunc func(path string, from, until time.Time) ([]Series, error)
+
+// Context holds the render parameters
+type Context struct {
+	From, Until time.Time
+	Fetch       FetchFunc
+}
+
+type function func(ctx Context, args []Expr) ([]Series, error)
+
+var functions map[string]function
+
+func init() {
+	functions = map[string]function{
+		"alias":     alias,
+		"scale":     scale,
+		"sumSeries": sumSeries,
+		"sum":       sumSeries,
+		"summarize": summarize,
+		"timeShift": timeShift,
+	}
+}
+
+// Eval evaluates a render target expression
+func Eval(e Expr, ctx Context) ([]Series, error) {
+	switch e.Kind {
+	case PathExpr:
+		return ctx.Fetch(e.Path, ctx.From, ctx.Until)
+
+	case CallExpr:
+		fun, ok := functions[e.Func]
+		if !ok {
+			return nil, fmt.Errorf("unsupported function: %s", e.Func)
+		}
+
+		return fun(ctx, e.Args)
+	}
+
+	return nil, fmt.Errorf("invalid series: %s", e)
+}
+
+// String formats the expression as Graphite target
+func (e Expr) String() string {
+	switch e.Kind {
+	case CallExpr:
+		args := make([]string, 0, len(e.Args))
+		for _, arg := range e.Args {
+			args = append(args, arg.String())
+		}
+		return fmt.Sprintf("%s(%s)", e.Func, strings.Join(args, ","))
+	case StringExpr:
+		return strconv.Quote(e.Str)
+	case NumberExpr:
+		return strconv.FormatFloat(e.Num, 'f', -1, 64)
+	default:
+		return e.Path
+	}
+}
+
+func stringArg(args []Expr, idx int, def string) (string, error) {
+	if idx >= len(args) {
+		return def, nil
+	}
+
+	if args[idx].Kind != StringExpr {
+		return "", fmt.Errorf("argument %d: string expected", idx+1)
+	}
+
+	return args[idx].Str, nil
+}
+
+func numberArg(args []Expr, idx int) (float64, error) {
+	if idx >= len(args) || args[idx].Kind != NumberExpr {
+		return 0, fmt.Errorf("argument %d: number expected", idx+1)
+	}
+
+	return args[idx].Num, nil
+}
+
+func seriesArg(ctx Context, args []Expr) ([]Series, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing series argument")
+	}
+
+	return Eval(args[0], ctx)
+}
+
+// alias(seriesList, newName)
+func alias(ctx Context, args []Expr) ([]Series, error) {
+	series, err := seriesArg(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := stringArg(args, 1, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range series {
+		series[idx].Target = name
+	}
+
+	return series, nil
+}
+
+// scale(seriesList, factor)
+func scale(ctx Context, args []Expr) ([]Series, error) {
+	series, err := seriesArg(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	factor, err := numberArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range series {
+		for i := range series[idx].Datapoints {
+			series[idx].Datapoints[i].Value *= factor
+		}
+		series[idx].Target = fmt.Sprintf("scale(%s,%g)", series[idx].Target, factor)
+	}
+
+	return series, nil
+}
+
+// seriesStep returns the smallest interval between the series' points or
+// 0 if the series has less than two points
+func seriesStep(s Series) int64 {
+	var step int64
+	for i := 1; i < len(s.Datapoints); i++ {
+		if d := s.Datapoints[i].Timestamp - s.Datapoints[i-1].Timestamp; d > 0 && (step == 0 || d < step) {
+			step = d
+		}
+	}
+
+	return step
+}
+
+// sumSeries(*seriesLists) adds all series. Series are consolidated to the
+// largest step of all series by averaging. A sum is null if any series is
+// missing a value for the step.
+func sumSeries(ctx Context, args []Expr) ([]Series, error) {
+	var all []Series
+	names := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		series, err := Eval(arg, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, arg.String())
+		all = append(all, series...)
+	}
+
+	step := int64(1)
+	for _, s := range all {
+		if d := seriesStep(s); d > step {
+			step = d
+		}
+	}
+
+	// consolidated values of each series by bucket
+	values := make([]map[int64]float64, len(all))
+	var first, last int64
+	var seen bool
+
+	for idx, s := range all {
+		sums := make(map[int64]float64)
+		counts := make(map[int64]int)
+
+		for _, p := range s.Datapoints {
+			bucket := p.Timestamp - p.Timestamp%step
+			if !seen || bucket < first {
+				first = bucket
+			}
+			if !seen || bucket > last {
+				last = bucket
+			}
+			seen = true
+
+			if _, ok := counts[bucket]; !ok {
+				counts[bucket] = 0
+			}
+			if !p.Null {
+				sums[bucket] += p.Value
+				counts[bucket]++
+			}
+		}
+
+		values[idx] = make(map[int64]float64, len(sums))
+		for bucket, sum := range sums {
+			values[idx][bucket] = sum / float64(counts[bucket])
+		}
+	}
+
+	res := Series{
+		Target:     fmt.Sprintf("sumSeries(%s)", strings.Join(names, ",")),
+		Datapoints: []Point{},
+	}
+
+	if !seen {
+		return []Series{res}, nil
+	}
+
+	for ts := first; ts <= last; ts += step {
+		p := Point{Timestamp: ts}
+
+		for _, v := range values {
+			value, ok := v[ts]
+			if !ok {
+				p = Point{Timestamp: ts, Null: true}
+				break
+			}
+			p.Value += value
+		}
+
+		res.Datapoints = append(res.Datapoints, p)
+	}
+
+	return []Series{res}, nil
+}
+
+// summarize(seriesList, intervalString, func='sum', alignToFrom=False)
+func summarize(ctx Context, args []Expr) ([]Series, error) {
+	series, err := seriesArg(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	intervalString, err := stringArg(args, 1, "")
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := interval.Parse(intervalString)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := int64(d / time.Second)
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid interval: %s", intervalString)
+	}
+
+	fun, err := stringArg(args, 2, "sum")
+	if err != nil {
+		return nil, err
+	}
+
+	aggregate, ok := aggregations[fun]
+	if !ok {
+		return nil, fmt.Errorf("unsupported summarize function: %s", fun)
+	}
+
+	var offset int64
+	if len(args) > 3 && strings.EqualFold(args[3].Path, "true") {
+		offset = ctx.From.Unix() % interval
+	}
+
+	for idx, s := range series {
+		buckets := make(map[int64][]float64)
+		var keys []int64
+
+		for _, p := range s.Datapoints {
+			bucket := p.Timestamp - (p.Timestamp-offset)%interval
+			if _, ok := buckets[bucket]; !ok {
+				keys = append(keys, bucket)
+				buckets[bucket] = nil
+			}
+			if !p.Null {
+				buckets[bucket] = append(buckets[bucket], p.Value)
+			}
+		}
+
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		points := make([]Point, 0, len(keys))
+		for _, key := range keys {
+			values := buckets[key]
+			if len(values) == 0 {
+				points = append(points, Point{Timestamp: key, Null: true})
+				continue
+			}
+
+			points = append(points, Point{Timestamp: key, Value: aggregate(values)})
+		}
+
+		series[idx] = Series{
+			Target:     fmt.Sprintf("summarize(%s, \"%s\", \"%s\")", s.Target, intervalString, fun),
+			Datapoints: points,
+		}
+	}
+
+	return series, nil
+}
+
+var aggregations = map[string]func([]float64) float64{
+	"sum": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"avg": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"max": func(values []float64) float64 {
+		max := math.Inf(-1)
+		for _, v := range values {
+			max = math.Max(max, v)
+		}
+		return max
+	},
+	"min": func(values []float64) float64 {
+		min := math.Inf(1)
+		for _, v := range values {
+			min = math.Min(min, v)
+		}
+		return min
+	},
+	"last": func(values []float64) float64 {
+		return values[len(values)-1]
+	},
+}
+
+// timeShift(seriesList, timeShift) draws the series shifted by the given
+// interval. Intervals without sign are shifted to the past.
+func timeShift(ctx Context, args []Expr) ([]Series, error) {
+	shiftString, err := stringArg(args, 1, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(shiftString, "+") && !strings.HasPrefix(shiftString, "-") {
+		shiftString = "-" + shiftString
+	}
+
+	shift, err := interval.Parse(shiftString)
+	if err != nil {
+		return nil, err
+	}
+
+	shifted := ctx
+	shifted.From = ctx.From.Add(shift)
+	shifted.Until = ctx.Until.Add(shift)
+
+	series, err := seriesArg(shifted, args)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := int64(shift / time.Second)
+	for idx := range series {
+		for i := range series[idx].Datapoints {
+			series[idx].Datapoints[i].Timestamp -= offset
+		}
+		series[idx].Target = fmt.Sprintf("timeShift(%s, \"%s\")", series[idx].Target, shiftString)
+	}
+
+	return series, nil
+}
@@ -0,0 +1,117 @@
+package graphite
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fetch returns the points of the named test series between from and until
+func fetch(path string, from, until time.Time) ([]Series, error) {
+	data := map[string][]Point{
+		"a": {{Value: 1, Timestamp: 0}, {Value: 2, Timestamp: 60}, {Value: 3, Timestamp: 120}, {Value: 4, Timestamp: 180}},
+		"b": {{Value: 10, Timestamp: 0}, {Value: 20, Timestamp: 120}},
+		"c": {{Value: 5, Timestamp: 0}, {Null: true, Timestamp: 120}},
+	}
+
+	res := []Series{}
+	for _, name := range []string{"a", "b", "c"} {
+		if path != name && path != "*" {
+			continue
+		}
+
+		s := Series{Target: name, Datapoints: []Point{}}
+		for _, p := range data[name] {
+			if p.Timestamp >= from.Unix() && p.Timestamp <= until.Unix() {
+				s.Datapoints = append(s.Datapoints, p)
+			}
+		}
+
+		res = append(res, s)
+	}
+
+	return res, nil
+}
+
+func TestEval(t *testing.T) {
+	ctx := Context{From: time.Unix(0, 0), Until: time.Unix(300, 0), Fetch: fetch}
+	aligned := Context{From: time.Unix(60, 0), Until: time.Unix(180, 0), Fetch: fetch}
+
+	points := func(values ...float64) []Point {
+		var res []Point
+		for i := 0; i < len(values); i += 2 {
+			res = append(res, Point{Timestamp: int64(values[i]), Value: values[i+1]})
+		}
+		return res
+	}
+
+	tc := []struct {
+		target   string
+		ctx      Context
+		expected []Series
+	}{
+		{"a", ctx, []Series{{"a", points(0, 1, 60, 2, 120, 3, 180, 4)}}},
+		{`alias(a, "x")`, ctx, []Series{{"x", points(0, 1, 60, 2, 120, 3, 180, 4)}}},
+		{"scale(a, 2)", ctx, []Series{{"scale(a,2)", points(0, 2, 60, 4, 120, 6, 180, 8)}}},
+		// consolidated to the largest step
+		{"sumSeries(a, b)", ctx, []Series{{"sumSeries(a,b)", points(0, 11.5, 120, 23.5)}}},
+		{"sum(*)", ctx, []Series{{"sumSeries(*)", []Point{{Timestamp: 0, Value: 16.5}, {Timestamp: 120, Null: true}}}}},
+		{"sumSeries(x)", ctx, []Series{{"sumSeries(x)", []Point{}}}},
+		{`summarize(a, "2min")`, ctx, []Series{{`summarize(a, "2min", "sum")`, points(0, 3, 120, 7)}}},
+		{`summarize(a, "2min", "avg")`, ctx, []Series{{`summarize(a, "2min", "avg")`, points(0, 1.5, 120, 3.5)}}},
+		{`summarize(a, "1h", "max")`, ctx, []Series{{`summarize(a, "1h", "max")`, points(0, 4)}}},
+		{`summarize(c, "1min", "last")`, ctx, []Series{{`summarize(c, "1min", "last")`, []Point{{Timestamp: 0, Value: 5}, {Timestamp: 120, Null: true}}}}},
+		{`summarize(a, "2min")`, aligned, []Series{{`summarize(a, "2min", "sum")`, points(0, 2, 120, 7)}}},
+		{`summarize(a, "2min", "sum", true)`, aligned, []Series{{`summarize(a, "2min", "sum")`, points(60, 5, 180, 4)}}},
+		// shifted to the past without sign
+		{`timeShift(a, "1min")`, aligned, []Series{{`timeShift(a, "-1min")`, points(60, 1, 120, 2, 180, 3)}}},
+		{`timeShift(a, "+1min")`, aligned, []Series{{`timeShift(a, "+1min")`, points(60, 3, 120, 4)}}},
+	}
+
+	for _, c := range tc {
+		e, err := Parse(c.target)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.target, err)
+			continue
+		}
+
+		res, err := Eval(e, c.ctx)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.target, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(res, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", c.target, c.expected, res)
+		}
+	}
+}
+
+func TestEvalInvalid(t *testing.T) {
+	ctx := Context{From: time.Unix(0, 0), Until: time.Unix(300, 0), Fetch: fetch}
+
+	for _, target := range []string{
+		"foo(a)",
+		"1",
+		`"a"`,
+		"alias()",
+		"alias(a, 1)",
+		"scale(a)",
+		`scale(a, "2")`,
+		`summarize(a, "foo")`,
+		`summarize(a, "0min")`,
+		`summarize(a, "1h", "median")`,
+		`timeShift(a, "1x")`,
+		"sumSeries(a, foo(b))",
+	} {
+		e, err := Parse(target)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", target, err)
+			continue
+		}
+
+		if _, err := Eval(e, ctx); err == nil {
+			t.Errorf("%s: expected error", target)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package graphite
+
+import "encoding/json"
+
+// Series is a named series of data points as returned by /render
+// https://graphite.readthedocs.io/en/latest/render_api.html#json
+type Series struct {
+	Target     string  `json:"target"`
+	Datapoints []Point `json:"datapoints"`
+}
+
+// Point is a single data point with timestamp in seconds
+type Point struct {
+	Value     float64
+	Null      bool
+	Timestamp int64
+}
+
+// MarshalJSON converts Point to json [value, timestamp]
+func (p Point) MarshalJSON() ([]byte, error) {
+	var value interface{} = p.Value
+	if p.Null {
+		value = nil
+	}
+
+	return json.Marshal([]interface{}{value, p.Timestamp})
+}
+
+// Node is a metric tree node as returned by /metrics/find
+// https://graphite.readthedocs.io/en/latest/metrics_api.html#metrics-find
+type Node struct {
+	Text          string                 `json:"text"`
+	ID            string                 `json:"id"`
+	Leaf          int                    `json:"leaf"`
+	Expandable    int                    `json:"expandable"`
+	AllowChildren int                    `json:"allowChildren"`
+	Context       map[string]interface{} `json:"context"`
+}
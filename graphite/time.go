@@ -0,0 +1,41 @@
+package graphite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andig/gravo/interval"
+)
+
+// ParseTime parses Graphite from/until values: now, relative intervals
+// like -1d or now-1d, unix timestamps and HH:MM_YYYYMMDD or YYYYMMDD.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "" || s == "now" {
+		return now, nil
+	}
+
+	if strings.HasPrefix(s, "now") {
+		s = s[len("now"):]
+	}
+
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		d, err := interval.Parse(s)
+		return now.Add(d), err
+	}
+
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil && len(s) != len("20060102") {
+		return time.Unix(ts, 0), nil
+	}
+
+	for _, layout := range []string{"15:04_20060102", "20060102"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return now, fmt.Errorf("invalid time: %s", s)
+}
@@ -0,0 +1,50 @@
+package graphite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.Local)
+
+	tc := []struct {
+		s        string
+		expected time.Time
+		err      bool
+	}{
+		{"", now, false},
+		{"now", now, false},
+		{"-1d", now.Add(-24 * time.Hour), false},
+		{"now-1h", now.Add(-time.Hour), false},
+		{"+2h", now.Add(2 * time.Hour), false},
+		{"-15min", now.Add(-15 * time.Minute), false},
+		{"1600000000", time.Unix(1600000000, 0), false},
+		{"20240101", time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local), false},
+		{"12:30_20240101", time.Date(2024, 1, 1, 12, 30, 0, 0, time.Local), false},
+		{"foo", time.Time{}, true},
+		{"now-", time.Time{}, true},
+		{"-1x", time.Time{}, true},
+		{"20241301", time.Time{}, true},
+	}
+
+	for _, c := range tc {
+		ts, err := ParseTime(c.s, now)
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected error", c.s)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.s, err)
+			continue
+		}
+
+		if !ts.Equal(c.expected) {
+			t.Errorf("%q: expected %v, got %v", c.s, c.expected, ts)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andig/gravo/grafana"
+	"github.com/andig/gravo/interval"
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// firstWeekday is the start of week for week grouping, Monday as in ISO
+// weeks by default
+var firstWeekday = time.Monday
+
+// parseWeekday parses weekday names like monday or sun
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || len(s) >= 2 && strings.HasPrefix(name, s) {
+			return d, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid weekday: %s", s)
+}
+
+// weekdayValue is a flag.Value for weekdays
+type weekdayValue struct {
+	day *time.Weekday
+}
+
+func (v weekdayValue) String() string {
+	if v.day == nil {
+		return ""
+	}
+	return strings.ToLower(v.day.String())
+}
+
+func (v weekdayValue) Set(s string) error {
+	d, err := parseWeekday(s)
+	if err == nil {
+		*v.day = d
+	}
+	return err
+}
+
+// regroups maps groups aggregated by gravo to the group queried from the
+// middleware
+var regroups = map[string]string{
+	"week":    "day",
+	"quarter": "month",
+	"year":    "month",
+}
+
+// calendarGroupRE matches durations in months (M) or years which are not
+// fixed durations
+var calendarGroupRE = regexp.MustCompile(`^(\d+)\s*(M|(?i:mon|months?|y|years?))$`)
+
+// parseGroup normalizes the group. Calendar durations like 1M, 3M or 1y are
+// mapped to the month, quarter and year groups, other calendar durations
+// are not supported.
+func parseGroup(group string) (string, error) {
+	group = strings.TrimSpace(group)
+
+	if match := calendarGroupRE.FindStringSubmatch(group); match != nil {
+		months, _ := strconv.Atoi(match[1])
+		if strings.HasPrefix(strings.ToLower(match[2]), "y") {
+			months *= 12
+		}
+
+		switch months {
+		case 1:
+			return "month", nil
+		case 3:
+			return "quarter", nil
+		case 12:
+			return "year", nil
+		}
+
+		return "", fmt.Errorf("unsupported group: %s, use month, quarter or year", group)
+	}
+
+	return strings.ToLower(group), nil
+}
+
+// groupDuration parses custom group durations like 15m, 2h or 7d
+func groupDuration(group string) (time.Duration, bool) {
+	if calendarGroupRE.MatchString(group) {
+		return 0, false
+	}
+
+	d, err := interval.Parse(group)
+	if err != nil || d < time.Second {
+		return 0, false
+	}
+
+	return d, true
+}
+
+// queryGroup returns the middleware group for querying group and if the
+// result must be regrouped by gravo. Custom durations use the largest
+// middleware group the duration is a multiple of or raw data. Other groups
+// are passed to the middleware.
+func queryGroup(group string) (string, bool) {
+	if base, ok := regroups[group]; ok {
+		return base, true
+	}
+
+	if d, ok := groupDuration(group); ok {
+		switch {
+		case d%(24*time.Hour) == 0:
+			return "day", true
+		case d%time.Hour == 0:
+			return "hour", true
+		default:
+			return "", true
+		}
+	}
+
+	return group, false
+}
+
+// dataQuery queries tuples of a middleware group
+type dataQuery func(from, to time.Time, group string, tuples int) ([]volkszaehler.Tuple, error)
+
+// queryGrouped queries data for [from, to) grouped by group. Groups not
+// supported by the middleware are aggregated by gravo from the middleware
+// group returned by queryGroup. Timestamps of grouped data are rounded to
+// period start.
+func queryGrouped(query dataQuery, from, to time.Time, group, options string, tuples int) ([]volkszaehler.Tuple, error) {
+	apiGroup, regrouped := queryGroup(group)
+
+	if regrouped {
+		// include complete first period
+		from = periodStart(from, group)
+		tuples = 0
+	}
+
+	data, err := query(from, to, apiGroup, tuples)
+	if err != nil {
+		return nil, err
+	}
+
+	if regrouped {
+		data = regroup(data, group, options)
+	}
+
+	if group != "" {
+		for i := range data {
+			data[i].Timestamp = roundTimestampMS(data[i].Timestamp, group)
+		}
+	}
+
+	return data, nil
+}
+
+// responseTuples converts middleware tuples into response data points
+func responseTuples(data []volkszaehler.Tuple) []grafana.ResponseTuple {
+	res := make([]grafana.ResponseTuple, 0, len(data))
+
+	for _, tuple := range data {
+		res = append(res, grafana.ResponseTuple{
+			Timestamp: tuple.Timestamp,
+			Value:     tuple.Value,
+			Null:      tuple.Null,
+			Count:     tuple.Count,
+		})
+	}
+
+	return res
+}
+
+// periodStart returns the start of the local time period containing t.
+// Custom durations are aligned to local midnight if they divide or are
+// multiples of a day and to the unix epoch otherwise. Multiples of a week
+// start on the first weekday.
+func periodStart(t time.Time, group string) time.Time {
+	t = t.In(time.Local)
+
+	switch group {
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.Local)
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	case "week":
+		offset := (int(t.Weekday()) - int(firstWeekday) + 7) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.Local)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	case "quarter":
+		return time.Date(t.Year(), t.Month()-(t.Month()-1)%3, 1, 0, 0, 0, 0, time.Local)
+	case "year":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.Local)
+	}
+
+	if d, ok := groupDuration(group); ok {
+		return durationStart(t, d)
+	}
+
+	return t
+}
+
+// durationStart returns the start of the custom duration period containing
+// the local time t
+func durationStart(t time.Time, d time.Duration) time.Time {
+	const day = 24 * time.Hour
+
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+
+	switch {
+	case d%day == 0:
+		// count local days since 1970-01-01, a thursday
+		days := int64(d / day)
+		n := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+
+		// whole weeks start on the first weekday
+		var offset int64
+		if days%7 == 0 {
+			offset = (int64(time.Thursday) - int64(firstWeekday) + 7) % 7
+		}
+
+		rem := (n + offset) % days
+		if rem < 0 {
+			rem += days
+		}
+
+		return time.Date(1970, 1, 1+int(n-rem), 0, 0, 0, 0, time.Local)
+	case day%d == 0:
+		return midnight.Add(t.Sub(midnight) / d * d)
+	default:
+		return t.Truncate(d)
+	}
+}
+
+// regroup aggregates tuples into periods of group. Consumption values are
+// summed, raw values like meter readings use the last value and all other
+// values like power are averaged weighted by the time covered by each
+// tuple.
+func regroup(data []volkszaehler.Tuple, group, options string) []volkszaehler.Tuple {
+	sum := strings.Contains(options, "consumption")
+	last := strings.Contains(options, "raw")
+
+	var res []volkszaehler.Tuple
+	var weighted, weights float64
+
+	// finish completes the average of the current period
+	finish := func() {
+		if len(res) > 0 && !sum && !last && weights > 0 {
+			res[len(res)-1].Value = weighted / weights
+		}
+	}
+
+	for i, tuple := range data {
+		if tuple.Null {
+			continue
+		}
+
+		start := unixMS(periodStart(fromUnixMS(tuple.Timestamp), group))
+
+		if len(res) == 0 || res[len(res)-1].Timestamp != start {
+			finish()
+			res = append(res, volkszaehler.Tuple{Timestamp: start})
+			weighted, weights = 0, 0
+		}
+
+		current := &res[len(res)-1]
+		current.Count += tuple.Count
+
+		switch {
+		case sum:
+			current.Value += tuple.Value
+		case last:
+			current.Value = tuple.Value
+		default:
+			// tuples cover the time since the previous tuple
+			weight := 1.0
+			if i > 0 {
+				weight = float64(tuple.Timestamp - data[i-1].Timestamp)
+			} else if len(data) > 1 {
+				weight = float64(data[1].Timestamp - tuple.Timestamp)
+			}
+
+			current.Value = tuple.Value // used if no time is covered
+			weighted += tuple.Value * weight
+			weights += weight
+		}
+	}
+
+	finish()
+
+	return res
+}
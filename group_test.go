@@ -0,0 +1,211 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// withLocation runs f with time.Local set to the named location
+func withLocation(t *testing.T, name string, f func()) {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	local := time.Local
+	time.Local = loc
+	defer func() { time.Local = local }()
+
+	f()
+}
+
+func TestParseGroup(t *testing.T) {
+	tc := []struct {
+		group, expected string
+		err             bool
+	}{
+		{"", "", false},
+		{"Hour", "hour", false},
+		{" day ", "day", false},
+		{"15m", "15m", false},
+		{"1M", "month", false},
+		{"1mon", "month", false},
+		{"1 month", "month", false},
+		{"3M", "quarter", false},
+		{"3months", "quarter", false},
+		{"12M", "year", false},
+		{"1y", "year", false},
+		{"1Year", "year", false},
+		{"2M", "", true},
+		{"6mon", "", true},
+		{"2y", "", true},
+	}
+
+	for _, c := range tc {
+		group, err := parseGroup(c.group)
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected error", c.group)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.group, err)
+			continue
+		}
+
+		if group != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.group, c.expected, group)
+		}
+	}
+}
+
+func TestQueryGroup(t *testing.T) {
+	tc := []struct {
+		group, apiGroup string
+		regrouped       bool
+	}{
+		{"", "", false},
+		{"hour", "hour", false},
+		{"month", "month", false},
+		{"week", "day", true},
+		{"quarter", "month", true},
+		{"year", "month", true},
+		{"15m", "", true},
+		{"30s", "", true},
+		{"2h", "hour", true},
+		{"2d", "day", true},
+		{"1w", "day", true},
+		{"90m", "", true},
+		{"500ms", "500ms", false},
+	}
+
+	for _, c := range tc {
+		apiGroup, regrouped := queryGroup(c.group)
+		if apiGroup != c.apiGroup || regrouped != c.regrouped {
+			t.Errorf("%q: expected %q %v, got %q %v", c.group, c.apiGroup, c.regrouped, apiGroup, regrouped)
+		}
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	withLocation(t, "Europe/Berlin", func() {
+		date := func(y int, m time.Month, d, h, min int) time.Time {
+			return time.Date(y, m, d, h, min, 0, 0, time.Local)
+		}
+
+		tc := []struct {
+			t        time.Time
+			group    string
+			weekday  time.Weekday
+			expected time.Time
+		}{
+			{date(2024, 3, 31, 3, 30), "hour", time.Monday, date(2024, 3, 31, 3, 0)},
+			{date(2024, 3, 31, 15, 0), "day", time.Monday, date(2024, 3, 31, 0, 0)},
+			{date(2024, 10, 27, 23, 0), "day", time.Monday, date(2024, 10, 27, 0, 0)},
+			{date(2024, 1, 3, 12, 0), "week", time.Monday, date(2024, 1, 1, 0, 0)},
+			{date(2024, 1, 3, 12, 0), "week", time.Sunday, date(2023, 12, 31, 0, 0)},
+			{date(2024, 1, 1, 0, 0), "week", time.Monday, date(2024, 1, 1, 0, 0)},
+			{date(2024, 5, 15, 12, 0), "month", time.Monday, date(2024, 5, 1, 0, 0)},
+			{date(2024, 5, 15, 12, 0), "quarter", time.Monday, date(2024, 4, 1, 0, 0)},
+			{date(2024, 3, 31, 23, 59), "quarter", time.Monday, date(2024, 1, 1, 0, 0)},
+			{date(2024, 12, 31, 23, 59), "quarter", time.Monday, date(2024, 10, 1, 0, 0)},
+			{date(2024, 12, 31, 23, 59), "year", time.Monday, date(2024, 1, 1, 0, 0)},
+			{date(2024, 1, 1, 10, 37), "15m", time.Monday, date(2024, 1, 1, 10, 30)},
+			{date(2024, 1, 1, 10, 37), "6h", time.Monday, date(2024, 1, 1, 6, 0)},
+			// periods shorter than a day are measured in elapsed time on dst days
+			{date(2024, 3, 31, 10, 0), "6h", time.Monday, date(2024, 3, 31, 7, 0)},
+			{date(2024, 1, 1, 12, 0), "2d", time.Monday, date(2023, 12, 31, 0, 0)},
+			{date(2024, 1, 2, 12, 0), "2d", time.Monday, date(2024, 1, 2, 0, 0)},
+			{date(2024, 3, 31, 12, 0), "1d", time.Monday, date(2024, 3, 31, 0, 0)},
+			{date(2024, 1, 3, 12, 0), "1w", time.Monday, date(2024, 1, 1, 0, 0)},
+			{date(2024, 1, 3, 12, 0), "7d", time.Monday, date(2024, 1, 1, 0, 0)},
+			{date(2024, 1, 3, 12, 0), "1w", time.Sunday, date(2023, 12, 31, 0, 0)},
+			{date(2024, 1, 10, 12, 0), "14d", time.Monday, date(2024, 1, 1, 0, 0)},
+			{date(2024, 1, 15, 0, 0), "14d", time.Monday, date(2024, 1, 15, 0, 0)},
+			{date(1969, 12, 31, 12, 0), "1w", time.Monday, date(1969, 12, 29, 0, 0)},
+			{date(2024, 1, 1, 10, 37), "", time.Monday, date(2024, 1, 1, 10, 37)},
+		}
+
+		weekday := firstWeekday
+		defer func() { firstWeekday = weekday }()
+
+		for _, c := range tc {
+			firstWeekday = c.weekday
+
+			if res := periodStart(c.t, c.group); !res.Equal(c.expected) {
+				t.Errorf("%v %s (%v): expected %v, got %v", c.t, c.group, c.weekday, c.expected, res)
+			}
+		}
+	})
+}
+
+func TestRegroup(t *testing.T) {
+	withLocation(t, "Europe/Berlin", func() {
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+		at := func(min int) int64 {
+			return unixMS(start.Add(time.Duration(min) * time.Minute))
+		}
+
+		data := []volkszaehler.Tuple{
+			{Timestamp: at(15), Value: 100, Count: 1},
+			{Timestamp: at(30), Value: 200, Count: 2},
+			{Timestamp: at(60), Null: true},
+			{Timestamp: at(90), Value: 400, Count: 3},
+			{Timestamp: at(150), Value: 50, Count: 4},
+		}
+
+		tc := []struct {
+			options  string
+			expected []volkszaehler.Tuple
+		}{
+			// power is weighted by the time since the previous tuple
+			{"", []volkszaehler.Tuple{
+				{Timestamp: at(0), Value: (100*15 + 200*15 + 400*30) / 60.0, Count: 6},
+				{Timestamp: at(120), Value: 50, Count: 4},
+			}},
+			{"consumption", []volkszaehler.Tuple{
+				{Timestamp: at(0), Value: 700, Count: 6},
+				{Timestamp: at(120), Value: 50, Count: 4},
+			}},
+			{"raw", []volkszaehler.Tuple{
+				{Timestamp: at(0), Value: 400, Count: 6},
+				{Timestamp: at(120), Value: 50, Count: 4},
+			}},
+		}
+
+		for _, c := range tc {
+			res := regroup(data, "2h", c.options)
+
+			if len(res) != len(c.expected) {
+				t.Errorf("%q: expected %v, got %v", c.options, c.expected, res)
+				continue
+			}
+
+			for i, tuple := range res {
+				e := c.expected[i]
+				if tuple.Timestamp != e.Timestamp || tuple.Count != e.Count || tuple.Null || math.Abs(tuple.Value-e.Value) > 1e-9 {
+					t.Errorf("%q: expected %+v, got %+v", c.options, e, tuple)
+				}
+			}
+		}
+
+		if res := regroup(nil, "2h", ""); len(res) != 0 {
+			t.Errorf("expected empty result, got %v", res)
+		}
+
+		if res := regroup([]volkszaehler.Tuple{{Timestamp: at(0), Null: true}}, "2h", ""); len(res) != 0 {
+			t.Errorf("expected nulls to be skipped, got %v", res)
+		}
+
+		// a single tuple covers no time
+		if res := regroup([]volkszaehler.Tuple{{Timestamp: at(10), Value: 5}}, "2h", ""); len(res) != 1 || res[0].Value != 5 {
+			t.Errorf("expected single tuple value, got %v", res)
+		}
+	})
+}
@@ -79,6 +79,13 @@ func (w loggingResponseWriter) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
+// Flush implements http.Flusher for streaming responses
+func (w loggingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // handler builds inbound request processing stack
 func handler(f http.HandlerFunc, debug bool) http.HandlerFunc {
 	return cors(
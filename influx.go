@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/andig/gravo/grafana"
+	"github.com/andig/gravo/influx"
+)
+
+// influxDatabase is the single database exposed via InfluxQL
+const influxDatabase = "volkszaehler"
+
+func (server *Server) influxPingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Influxdb-Version", "1.8-gravo")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) influxQueryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	resp := influx.Response{
+		Results: []influx.Result{},
+	}
+
+	statements, err := influx.Parse(r.FormValue("q"), time.Now())
+	if err != nil {
+		resp.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	epoch := r.FormValue("epoch")
+	for idx, stmt := range statements {
+		resp.Results = append(resp.Results, server.executeInflux(idx, stmt, epoch))
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+// executeInflux executes a single InfluxQL statement
+func (server *Server) executeInflux(idx int, stmt influx.Statement, epoch string) influx.Result {
+	res := influx.Result{
+		StatementID: idx,
+	}
+
+	switch stmt.Type {
+	case influx.ShowDatabases:
+		res.Series = []influx.Series{{
+			Name:    "databases",
+			Columns: []string{"name"},
+			Values:  [][]interface{}{{influxDatabase}},
+		}}
+
+	case influx.ShowMeasurements:
+		series := influx.Series{
+			Name:    "measurements",
+			Columns: []string{"name"},
+			Values:  [][]interface{}{},
+		}
+
+		for _, entity := range server.getPublicEntites() {
+			series.Values = append(series.Values, []interface{}{entity.Title})
+		}
+
+		res.Series = []influx.Series{series}
+
+	case influx.ShowFieldKeys:
+		for _, entity := range server.getPublicEntites() {
+			res.Series = append(res.Series, influx.Series{
+				Name:    entity.Title,
+				Columns: []string{"fieldKey", "fieldType"},
+				Values:  [][]interface{}{{"value", "float"}},
+			})
+		}
+
+	case influx.ShowTagKeys:
+		// no tags
+
+	case influx.Select:
+		for _, measurement := range stmt.Measurements {
+			res.Series = append(res.Series, server.influxSelect(stmt, measurement, epoch))
+		}
+	}
+
+	return res
+}
+
+// influxSelect maps a select statement onto a data query. Group by
+// intervals of one hour or one day use middleware aggregation, other
+// intervals are mapped to the number of tuples.
+func (server *Server) influxSelect(stmt influx.Statement, measurement string, epoch string) influx.Series {
+	target := grafana.Target{
+		Target: server.resolveUUID(measurement),
+	}
+
+	qr := grafana.QueryRequest{
+		Range: grafana.Range{
+			From: stmt.From,
+			To:   stmt.To,
+		},
+	}
+
+	switch {
+	case stmt.Interval == time.Hour:
+		target.Data.Group = "hour"
+	case stmt.Interval == 24*time.Hour:
+		target.Data.Group = "day"
+	case stmt.Interval > 0:
+		qr.MaxDataPoints = int(math.Ceil(float64(stmt.To.Sub(stmt.From)) / float64(stmt.Interval)))
+	case stmt.Function == "mean":
+		qr.MaxDataPoints = 1
+	}
+
+	qres := server.queryData(target, &qr)
+
+	datapoints := qres.Datapoints
+	switch stmt.Function {
+	case "first":
+		if len(datapoints) > 1 {
+			datapoints = datapoints[:1]
+		}
+	case "last":
+		if len(datapoints) > 1 {
+			datapoints = datapoints[len(datapoints)-1:]
+		}
+	}
+
+	if stmt.Descending {
+		reversed := make([]grafana.ResponseTuple, len(datapoints))
+		for i, dp := range datapoints {
+			reversed[len(datapoints)-1-i] = dp
+		}
+		datapoints = reversed
+	}
+
+	if stmt.Limit > 0 && len(datapoints) > stmt.Limit {
+		datapoints = datapoints[:stmt.Limit]
+	}
+
+	series := influx.Series{
+		Name:    measurement,
+		Columns: []string{"time", stmt.Column},
+		Values:  make([][]interface{}, 0, len(datapoints)),
+	}
+
+	for _, dp := range datapoints {
+		var value interface{} = dp.Value
+		if dp.Null {
+			value = nil
+		}
+
+		series.Values = append(series.Values, []interface{}{influxTime(dp.Timestamp, epoch), value})
+	}
+
+	return series
+}
+
+// influxTime formats a timestamp in milliseconds according to the epoch
+// query parameter
+func influxTime(ts int64, epoch string) interface{} {
+	switch epoch {
+	case "h":
+		return ts / int64(time.Hour/time.Millisecond)
+	case "m":
+		return ts / int64(time.Minute/time.Millisecond)
+	case "s":
+		return ts / 1e3
+	case "ms":
+		return ts
+	case "u", "us", "µ":
+		return ts * 1e3
+	case "ns", "n":
+		return ts * 1e6
+	default:
+		return time.Unix(0, ts*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano)
+	}
+}
@@ -0,0 +1,168 @@
+package influx
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point is a single line protocol data point
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64
+	Time        time.Time
+}
+
+// precisions maps the write precision parameter to timestamp units
+var precisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"µ":  time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// ParseLines parses InfluxDB line protocol
+// https://docs.influxdata.com/influxdb/v1.8/write_protocols/line_protocol_reference/
+//
+//	<measurement>[,<tag>=<value>...] <field>=<value>[,<field>=<value>...] [<timestamp>]
+//
+// Field values must be numeric or boolean. Points without timestamp use now.
+func ParseLines(body string, precision string, now time.Time) ([]Point, error) {
+	unit, ok := precisions[precision]
+	if !ok {
+		return nil, fmt.Errorf("invalid precision: %s", precision)
+	}
+
+	var res []Point
+
+	for idx, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p, err := parseLine(line, unit, now)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", idx+1, err)
+		}
+
+		res = append(res, p)
+	}
+
+	return res, nil
+}
+
+func parseLine(line string, unit time.Duration, now time.Time) (Point, error) {
+	p := Point{
+		Tags:   make(map[string]string),
+		Fields: make(map[string]float64),
+		Time:   now,
+	}
+
+	var parts []string
+	for _, part := range splitEscaped(line, ' ') {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) < 2 || len(parts) > 3 {
+		return p, fmt.Errorf("invalid line: %s", line)
+	}
+
+	key := splitEscaped(parts[0], ',')
+	if p.Measurement = unescape(key[0]); p.Measurement == "" {
+		return p, fmt.Errorf("missing measurement: %s", line)
+	}
+
+	for _, tag := range key[1:] {
+		kv := splitEscaped(tag, '=')
+		if len(kv) != 2 {
+			return p, fmt.Errorf("invalid tag: %s", tag)
+		}
+		p.Tags[unescape(kv[0])] = unescape(kv[1])
+	}
+
+	for _, field := range splitEscaped(parts[1], ',') {
+		kv := splitEscaped(field, '=')
+		if len(kv) != 2 {
+			return p, fmt.Errorf("invalid field: %s", field)
+		}
+
+		value, err := parseFieldValue(kv[1])
+		if err != nil {
+			return p, err
+		}
+		p.Fields[unescape(kv[0])] = value
+	}
+
+	if len(parts) == 3 {
+		ts, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid timestamp: %s", parts[2])
+		}
+		p.Time = time.Unix(0, ts*int64(unit))
+	}
+
+	return p, nil
+}
+
+func parseFieldValue(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "t", "true":
+		return 1, nil
+	case "f", "false":
+		return 0, nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		return 0, fmt.Errorf("string field values not supported: %s", s)
+	}
+
+	s = strings.TrimRight(s, "iu")
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid field value: %s", s)
+	}
+
+	return f, nil
+}
+
+// splitEscaped splits s at sep unless escaped by backslash or quoted
+func splitEscaped(s string, sep byte) []string {
+	var res []string
+	var quoted bool
+	var start int
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				res = append(res, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(res, s[start:])
+}
+
+var unescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\"`, `"`, `\\`, `\`)
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
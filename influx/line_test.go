@@ -0,0 +1,97 @@
+package influx
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLines(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	tc := []struct {
+		body, precision string
+		points          []Point
+		err             bool
+	}{
+		{
+			"power value=12.5 1600000000000000000", "",
+			[]Point{{"power", map[string]string{}, map[string]float64{"value": 12.5}, time.Unix(1600000000, 0)}},
+			false,
+		},
+		{
+			"power,uuid=abc value=1i 1600000000000", "ms",
+			[]Point{{"power", map[string]string{"uuid": "abc"}, map[string]float64{"value": 1}, time.Unix(1600000000, 0)}},
+			false,
+		},
+		{
+			"power value=1 1600000000", "s",
+			[]Point{{"power", map[string]string{}, map[string]float64{"value": 1}, time.Unix(1600000000, 0)}},
+			false,
+		},
+		{
+			"power value=1", "",
+			[]Point{{"power", map[string]string{}, map[string]float64{"value": 1}, now}},
+			false,
+		},
+		{
+			"power value=t,other=F,count=3u", "",
+			[]Point{{"power", map[string]string{}, map[string]float64{"value": 1, "other": 0, "count": 3}, now}},
+			false,
+		},
+		{
+			`House/Living\ Room,room=a\,b value=21.5`, "",
+			[]Point{{"House/Living Room", map[string]string{"room": "a,b"}, map[string]float64{"value": 21.5}, now}},
+			false,
+		},
+		{
+			"# comment\n\npower value=1\nenergy value=2\n", "",
+			[]Point{
+				{"power", map[string]string{}, map[string]float64{"value": 1}, now},
+				{"energy", map[string]string{}, map[string]float64{"value": 2}, now},
+			},
+			false,
+		},
+		{"", "", nil, false},
+		{"power value=1", "x", nil, true},
+		{"power", "", nil, true},
+		{"power value=1 2 3", "", nil, true},
+		{",uuid=abc value=1", "", nil, true},
+		{"power,uuid value=1", "", nil, true},
+		{"power value", "", nil, true},
+		{`power value="on"`, "", nil, true},
+		{"power value=foo", "", nil, true},
+		{"power value=NaN", "", nil, true},
+		{"power value=1 foo", "", nil, true},
+		{"power value=1\npower", "", nil, true},
+	}
+
+	for _, c := range tc {
+		points, err := ParseLines(c.body, c.precision, now)
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected error", c.body)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.body, err)
+			continue
+		}
+
+		if len(points) != len(c.points) {
+			t.Errorf("%q: expected %d points, got %d", c.body, len(c.points), len(points))
+			continue
+		}
+
+		for i, p := range points {
+			e := c.points[i]
+			if p.Measurement != e.Measurement || !reflect.DeepEqual(p.Tags, e.Tags) ||
+				!reflect.DeepEqual(p.Fields, e.Fields) || !p.Time.Equal(e.Time) {
+				t.Errorf("%q: expected %+v, got %+v", c.body, e, p)
+			}
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package influx
+
+// Response is the InfluxDB /query response
+// https://docs.influxdata.com/influxdb/v1.8/tools/api/#query-http-endpoint
+type Response struct {
+	Results []Result `json:"results"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Result is the result of a single statement
+type Result struct {
+	StatementID int      `json:"statement_id"`
+	Series      []Series `json:"series,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// Series is a named table of values
+type Series struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Values  [][]interface{} `json:"values"`
+}
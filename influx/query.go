@@ -0,0 +1,289 @@
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statement types
+const (
+	Select           = "select"
+	ShowDatabases    = "show databases"
+	ShowMeasurements = "show measurements"
+	ShowFieldKeys    = "show field keys"
+	ShowTagKeys      = "show tag keys"
+)
+
+// Statement is a parsed InfluxQL statement. Only a subset of InfluxQL is
+// supported:
+//
+//	SELECT mean(value) FROM "<measurement>"[, ...] WHERE time > now() - 1d GROUP BY time(1h) ORDER BY time DESC LIMIT 10
+//	SHOW DATABASES | MEASUREMENTS | FIELD KEYS | TAG KEYS
+type Statement struct {
+	Type         string
+	Function     string // mean, first, last or empty for raw values
+	Column       string // result column name
+	Measurements []string
+	From, To     time.Time
+	Interval     time.Duration
+	Descending   bool // ORDER BY time DESC
+	Limit        int
+}
+
+var (
+	selectRE = regexp.MustCompile(`(?is)^SELECT\s+(.+?)\s+FROM\s+(.+?)` +
+		`(?:\s+WHERE\s+(.+?))?(?:\s+GROUP\s+BY\s+(.+?))?(?:\s+FILL\s*\(\w+\))?` +
+		`(?:\s+ORDER\s+BY\s+time(?:\s+(ASC|DESC))?)?(?:\s+LIMIT\s+(\d+))?$`)
+	fieldRE     = regexp.MustCompile(`(?i)^(?:(\w+)\(\s*)?("?)(\w+|\*)("?)(?:\s*\))?(?:\s+AS\s+"?(\w+)"?)?$`)
+	conditionRE = regexp.MustCompile(`(?i)^\(?\s*time\s*(>=|<=|>|<|=)\s*(.+?)\s*\)?$`)
+	groupByRE   = regexp.MustCompile(`(?i)time\(\s*(\w+)\s*\)`)
+	nowRE       = regexp.MustCompile(`(?i)^now\(\)\s*(?:([+-])\s*(\w+))?$`)
+	durationRE  = regexp.MustCompile(`(\d+)(ns|us|u|µ|ms|s|m|h|d|w)`)
+	durationsRE = regexp.MustCompile(`^(?:\d+(?:ns|us|u|µ|ms|s|m|h|d|w))+$`)
+	andRE       = regexp.MustCompile(`(?i)\s+AND\s+`)
+)
+
+// supported aggregation functions
+var functions = map[string]bool{
+	"mean":  true,
+	"first": true,
+	"last":  true,
+}
+
+// Parse parses semicolon-separated InfluxQL statements relative to now
+func Parse(q string, now time.Time) ([]Statement, error) {
+	var res []Statement
+
+	for _, stmt := range strings.Split(q, ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			continue
+		}
+
+		s, err := parseStatement(stmt, now)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, s)
+	}
+
+	if len(res) == 0 {
+		return nil, errors.New("missing query")
+	}
+
+	return res, nil
+}
+
+func parseStatement(stmt string, now time.Time) (Statement, error) {
+	lower := strings.ToLower(strings.Join(strings.Fields(stmt), " "))
+	for _, typ := range []string{ShowDatabases, ShowMeasurements, ShowFieldKeys, ShowTagKeys} {
+		if strings.HasPrefix(lower, typ) {
+			return Statement{Type: typ}, nil
+		}
+	}
+
+	match := selectRE.FindStringSubmatch(stmt)
+	if match == nil {
+		return Statement{}, fmt.Errorf("unsupported statement: %s", stmt)
+	}
+
+	s := Statement{
+		Type: Select,
+		From: now.Add(-time.Hour),
+		To:   now,
+	}
+
+	if err := s.parseField(strings.TrimSpace(match[1])); err != nil {
+		return s, err
+	}
+
+	for _, from := range split(match[2], ',') {
+		// skip database and retention policy of "db"."rp"."measurement"
+		ids := split(from, '.')
+		if id := unquote(ids[len(ids)-1]); id != "" {
+			s.Measurements = append(s.Measurements, id)
+		}
+	}
+
+	if len(s.Measurements) == 0 {
+		return s, fmt.Errorf("missing measurement: %s", stmt)
+	}
+
+	if match[3] != "" {
+		if err := s.parseWhere(match[3], now); err != nil {
+			return s, err
+		}
+	}
+
+	if match[4] != "" {
+		if m := groupByRE.FindStringSubmatch(match[4]); m != nil {
+			d, err := ParseDuration(m[1])
+			if err != nil {
+				return s, err
+			}
+			s.Interval = d
+		}
+	}
+
+	s.Descending = strings.EqualFold(match[5], "desc")
+
+	if match[6] != "" {
+		s.Limit, _ = strconv.Atoi(match[6])
+	}
+
+	if s.Function != "" && s.Function != "mean" && s.Interval != 0 {
+		return s, fmt.Errorf("unsupported function with group by: %s", s.Function)
+	}
+
+	return s, nil
+}
+
+func (s *Statement) parseField(field string) error {
+	match := fieldRE.FindStringSubmatch(field)
+	if match == nil {
+		return fmt.Errorf("unsupported field: %s", field)
+	}
+
+	s.Function = strings.ToLower(match[1])
+	if s.Function != "" && !functions[s.Function] {
+		return fmt.Errorf("unsupported function: %s", s.Function)
+	}
+
+	s.Column = "value"
+	if s.Function != "" {
+		s.Column = s.Function
+	}
+	if match[5] != "" {
+		s.Column = match[5]
+	}
+
+	return nil
+}
+
+func (s *Statement) parseWhere(where string, now time.Time) error {
+	for _, cond := range andRE.Split(strings.TrimSpace(where), -1) {
+		match := conditionRE.FindStringSubmatch(strings.TrimSpace(cond))
+		if match == nil {
+			return fmt.Errorf("unsupported condition: %s", cond)
+		}
+
+		ts, err := parseTime(match[2], now)
+		if err != nil {
+			return err
+		}
+
+		switch match[1] {
+		case ">", ">=":
+			s.From = ts
+		case "<", "<=":
+			s.To = ts
+		case "=":
+			s.From, s.To = ts, ts
+		}
+	}
+
+	return nil
+}
+
+// parseTime parses now() based, RFC3339 string or epoch time expressions
+func parseTime(expr string, now time.Time) (time.Time, error) {
+	expr = strings.TrimSpace(expr)
+
+	if match := nowRE.FindStringSubmatch(expr); match != nil {
+		if match[2] == "" {
+			return now, nil
+		}
+
+		d, err := ParseDuration(match[2])
+		if err != nil {
+			return now, err
+		}
+
+		if match[1] == "-" {
+			d = -d
+		}
+
+		return now.Add(d), nil
+	}
+
+	if strings.HasPrefix(expr, "'") && strings.HasSuffix(expr, "'") {
+		return time.Parse(time.RFC3339Nano, strings.Trim(expr, "'"))
+	}
+
+	unit := time.Nanosecond
+	for _, suffix := range []string{"ns", "ms", "us", "u", "s"} {
+		if strings.HasSuffix(expr, suffix) {
+			expr = strings.TrimSuffix(expr, suffix)
+			unit, _ = ParseDuration("1" + suffix)
+			break
+		}
+	}
+
+	epoch, err := strconv.ParseInt(expr, 10, 64)
+	if err != nil {
+		return now, fmt.Errorf("invalid time: %s", expr)
+	}
+
+	return time.Unix(0, epoch*int64(unit)), nil
+}
+
+// ParseDuration parses InfluxQL duration literals like 1h30m or 1d
+func ParseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"ns": time.Nanosecond,
+		"us": time.Microsecond,
+		"u":  time.Microsecond,
+		"µ":  time.Microsecond,
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"m":  time.Minute,
+		"h":  time.Hour,
+		"d":  24 * time.Hour,
+		"w":  7 * 24 * time.Hour,
+	}
+
+	if !durationsRE.MatchString(s) {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+
+	var d time.Duration
+	for _, match := range durationRE.FindAllStringSubmatch(s, -1) {
+		n, _ := strconv.ParseInt(match[1], 10, 64)
+		d += time.Duration(n) * units[match[2]]
+	}
+
+	return d, nil
+}
+
+// split splits s at sep outside of double quotes
+func split(s string, sep rune) []string {
+	var res []string
+	var quoted bool
+	start := 0
+
+	for i, c := range s {
+		switch {
+		case c == '"' && (i == 0 || s[i-1] != '\\'):
+			quoted = !quoted
+		case c == sep && !quoted:
+			res = append(res, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(res, strings.TrimSpace(s[start:]))
+}
+
+// unquote removes identifier quotes
+func unquote(id string) string {
+	if len(id) >= 2 && strings.HasPrefix(id, `"`) && strings.HasSuffix(id, `"`) {
+		id = strings.Replace(id[1:len(id)-1], `\"`, `"`, -1)
+	}
+
+	return id
+}
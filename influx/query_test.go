@@ -0,0 +1,142 @@
+package influx
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	tc := []struct {
+		q        string
+		expected Statement
+	}{
+		{
+			`SELECT mean("value") FROM "power" WHERE time > now() - 1d GROUP BY time(1h) fill(null) ORDER BY time DESC LIMIT 10`,
+			Statement{Type: Select, Function: "mean", Column: "mean", Measurements: []string{"power"},
+				From: now.Add(-24 * time.Hour), To: now, Interval: time.Hour, Descending: true, Limit: 10},
+		},
+		{
+			`select value from "db"."autogen"."House/Grid", cpu where time >= '2020-09-13T12:26:40Z' and time <= 1600003600000ms`,
+			Statement{Type: Select, Column: "value", Measurements: []string{"House/Grid", "cpu"},
+				From: now, To: now.Add(time.Hour)},
+		},
+		{
+			`SELECT last(value) AS "latest" FROM power`,
+			Statement{Type: Select, Function: "last", Column: "latest", Measurements: []string{"power"},
+				From: now.Add(-time.Hour), To: now},
+		},
+		{
+			`SELECT * FROM "quoted \"name\"" WHERE (time = now())`,
+			Statement{Type: Select, Column: "value", Measurements: []string{`quoted "name"`}, From: now, To: now},
+		},
+		{
+			`SELECT mean(value) FROM power WHERE time > 1600000000000000000 AND time < now() + 1h30m GROUP BY time(15m) ORDER BY time ASC`,
+			Statement{Type: Select, Function: "mean", Column: "mean", Measurements: []string{"power"},
+				From: now, To: now.Add(90 * time.Minute), Interval: 15 * time.Minute},
+		},
+		{`SHOW  measurements`, Statement{Type: ShowMeasurements}},
+		{`show field keys from power`, Statement{Type: ShowFieldKeys}},
+		{`SHOW TAG KEYS`, Statement{Type: ShowTagKeys}},
+	}
+
+	for _, c := range tc {
+		res, err := Parse(c.q, now)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.q, err)
+			continue
+		}
+
+		if len(res) != 1 {
+			t.Errorf("%s: expected 1 statement, got %d", c.q, len(res))
+			continue
+		}
+
+		s, e := res[0], c.expected
+		if !s.From.Equal(e.From) || !s.To.Equal(e.To) {
+			t.Errorf("%s: expected %v - %v, got %v - %v", c.q, e.From, e.To, s.From, s.To)
+		}
+
+		s.From, s.To, e.From, e.To = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+		if !reflect.DeepEqual(s, e) {
+			t.Errorf("%s: expected %+v, got %+v", c.q, e, s)
+		}
+	}
+}
+
+func TestParseMultiple(t *testing.T) {
+	res, err := Parse("SHOW DATABASES; SELECT value FROM power;", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 2 || res[0].Type != ShowDatabases || res[1].Type != Select {
+		t.Errorf("expected show databases and select, got %+v", res)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, q := range []string{
+		"",
+		" ; ",
+		"DELETE FROM power",
+		"SELECT max(value) FROM power",
+		"SELECT value + 1 FROM power",
+		"SELECT first(value) FROM power GROUP BY time(1h)",
+		"SELECT value FROM power WHERE host = 'a'",
+		"SELECT value FROM power WHERE time > now() - 1x",
+		"SELECT value FROM power WHERE time > 'foo'",
+		"SELECT value FROM power WHERE time > foo",
+		"SELECT mean(value) FROM power GROUP BY time(1y)",
+		`SELECT value FROM ""`,
+		"SELECT value FROM power; SHOW",
+	} {
+		if _, err := Parse(q, time.Now()); err == nil {
+			t.Errorf("%q: expected error", q)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tc := []struct {
+		s        string
+		expected time.Duration
+		err      bool
+	}{
+		{"1h30m", 90 * time.Minute, false},
+		{"1d", 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"1m5ms", time.Minute + 5*time.Millisecond, false},
+		{"10u", 10 * time.Microsecond, false},
+		{"1µ", time.Microsecond, false},
+		{"100ns", 100, false},
+		{"", 0, true},
+		{"1", 0, true},
+		{"h", 0, true},
+		{"1y", 0, true},
+		{"1h 30m", 0, true},
+		{"-1h", 0, true},
+	}
+
+	for _, c := range tc {
+		d, err := ParseDuration(c.s)
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected error", c.s)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.s, err)
+			continue
+		}
+
+		if d != c.expected {
+			t.Errorf("%q: expected %v, got %v", c.s, c.expected, d)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andig/gravo/influx"
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// ingestLimit is the maximum number of buffered tuples
+const ingestLimit = 100000
+
+var errIngestBufferFull = errors.New("ingest buffer full")
+
+// ingestBuffer collects written tuples per channel and forwards them to
+// the middleware in batches. Tuples of requests failed due to network or
+// server errors are kept and retried on the next flush, tuples rejected by
+// the middleware are dropped.
+type ingestBuffer struct {
+	api     volkszaehler.Writer
+	limit   int
+	mux     sync.Mutex // guards pending and size
+	pending map[string][]volkszaehler.Tuple
+	size    int
+
+	// written is called with the tuples of successful writes
+	written func(uuid string, tuples []volkszaehler.Tuple)
+}
+
+func newIngestBuffer(api volkszaehler.Writer, limit int, written func(string, []volkszaehler.Tuple)) *ingestBuffer {
+	return &ingestBuffer{
+		api:     api,
+		limit:   limit,
+		written: written,
+		pending: make(map[string][]volkszaehler.Tuple),
+	}
+}
+
+// add buffers the tuples unless the buffer limit is exceeded
+func (buffer *ingestBuffer) add(batch map[string][]volkszaehler.Tuple) error {
+	var count int
+	for _, tuples := range batch {
+		count += len(tuples)
+	}
+
+	buffer.mux.Lock()
+	defer buffer.mux.Unlock()
+
+	if buffer.size+count > buffer.limit {
+		return errIngestBufferFull
+	}
+
+	for uuid, tuples := range batch {
+		buffer.pending[uuid] = append(buffer.pending[uuid], tuples...)
+	}
+	buffer.size += count
+
+	return nil
+}
+
+// flush posts the pending tuples per channel
+func (buffer *ingestBuffer) flush() {
+	buffer.mux.Lock()
+	pending := buffer.pending
+	buffer.pending = make(map[string][]volkszaehler.Tuple)
+	buffer.mux.Unlock()
+
+	for uuid, tuples := range pending {
+		_, err := buffer.api.PostData(uuid, tuples)
+
+		var exception *volkszaehler.ExceptionError
+		permanent := errors.As(err, &exception) && exception.Permanent()
+
+		buffer.mux.Lock()
+		switch {
+		case err == nil:
+			buffer.size -= len(tuples)
+		case permanent:
+			log.Printf("ingest failed: %s: %v, dropping %d tuples", uuid, err, len(tuples))
+			buffer.size -= len(tuples)
+		default:
+			log.Printf("ingest failed: %s: %v", uuid, err)
+			// keep order for retry
+			buffer.pending[uuid] = append(tuples, buffer.pending[uuid]...)
+		}
+		buffer.mux.Unlock()
+
+		if err == nil && buffer.written != nil {
+			buffer.written(uuid, tuples)
+		}
+	}
+}
+
+// run flushes the buffer periodically until done is closed
+func (buffer *ingestBuffer) run(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			buffer.flush()
+		case <-done:
+			buffer.flush()
+			return
+		}
+	}
+}
+
+// ingestTokens parses the comma-separated list of uuid:token pairs
+func ingestTokens(list string) (map[string]string, error) {
+	res := make(map[string]string)
+
+	for _, pair := range strings.Split(list, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		segments := strings.SplitN(pair, ":", 2)
+		if len(segments) != 2 || segments[0] == "" || segments[1] == "" {
+			return nil, fmt.Errorf("invalid ingest token: %s", pair)
+		}
+
+		res[segments[0]] = segments[1]
+	}
+
+	return res, nil
+}
+
+// writer returns the api client if it supports writing data
+func (server *Server) writer() (volkszaehler.Writer, error) {
+	writer, ok := server.api.(volkszaehler.Writer)
+	if !ok {
+		return nil, errors.New("api client does not support writing data")
+	}
+
+	return writer, nil
+}
+
+// enableIngest accepts writes for channels with configured token and
+// forwards them to the middleware every interval
+func (server *Server) enableIngest(tokens map[string]string, interval time.Duration) error {
+	writer, err := server.writer()
+	if err != nil {
+		return err
+	}
+
+	server.tokens = tokens
+	server.ingest = newIngestBuffer(writer, ingestLimit, server.invalidateRollups)
+
+	server.flushes.Add(1)
+	go func() {
+		server.ingest.run(interval, server.done)
+		server.flushes.Done()
+	}()
+
+	return nil
+}
+
+// requestToken returns the token given as bearer token, as basic auth or
+// InfluxDB password or as token parameter
+func requestToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	for _, scheme := range []string{"Bearer ", "Token "} {
+		if strings.HasPrefix(auth, scheme) {
+			return strings.TrimSpace(auth[len(scheme):])
+		}
+	}
+
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+
+	return r.URL.Query().Get("p")
+}
+
+// authorize checks if the request may write to all channels of batch
+func (server *Server) authorize(r *http.Request, batch map[string][]volkszaehler.Tuple) error {
+	token := requestToken(r)
+
+	for uuid := range batch {
+		expected, ok := server.tokens[uuid]
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			return fmt.Errorf("not authorized for channel %s", uuid)
+		}
+	}
+
+	return nil
+}
+
+// validateTuples checks tuples for valid timestamps and values
+func validateTuples(tuples []volkszaehler.Tuple) error {
+	if len(tuples) == 0 {
+		return errors.New("no tuples")
+	}
+
+	for _, tuple := range tuples {
+		if tuple.Timestamp <= 0 {
+			return fmt.Errorf("invalid timestamp: %d", tuple.Timestamp)
+		}
+		if tuple.Null {
+			return fmt.Errorf("missing value at %d", tuple.Timestamp)
+		}
+	}
+
+	return nil
+}
+
+// writeBatch authorizes and buffers the batch
+func (server *Server) writeBatch(w http.ResponseWriter, r *http.Request, batch map[string][]volkszaehler.Tuple) {
+	if err := server.authorize(r, batch); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err := server.ingest.add(batch); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ingestHandler accepts a JSON array of [timestamp, value] tuples or a
+// single tuple for the channel given by uuid or title path:
+//
+//	POST /ingest/<uuid|title>
+func (server *Server) ingestHandler(w http.ResponseWriter, r *http.Request) {
+	if server.ingest == nil {
+		http.Error(w, "ingest disabled", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Bad method; supported POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/ingest/"), ".json")
+	uuid := server.resolveUUID(target)
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var tuples []volkszaehler.Tuple
+	if err := json.Unmarshal(body, &tuples); err != nil {
+		var tuple volkszaehler.Tuple
+		if err := json.Unmarshal(body, &tuple); err != nil {
+			log.Printf("json decode failed: %v", err)
+			http.Error(w, fmt.Sprintf("json decode failed: %v", err), http.StatusBadRequest)
+
+			return
+		}
+
+		tuples = []volkszaehler.Tuple{tuple}
+	}
+
+	if err := validateTuples(tuples); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	server.writeBatch(w, r, map[string][]volkszaehler.Tuple{uuid: tuples})
+}
+
+// influxWriteHandler accepts InfluxDB line protocol. The channel is given
+// by the uuid tag or the measurement as uuid or title path. The value is
+// taken from the value field or the only field of the point.
+func (server *Server) influxWriteHandler(w http.ResponseWriter, r *http.Request) {
+	if server.ingest == nil {
+		http.Error(w, "ingest disabled", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Bad method; supported POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	points, err := influx.ParseLines(string(body), r.URL.Query().Get("precision"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	batch := make(map[string][]volkszaehler.Tuple)
+
+	for _, p := range points {
+		uuid := p.Tags["uuid"]
+		if uuid == "" {
+			uuid = server.resolveUUID(p.Measurement)
+		}
+
+		value, ok := p.Fields["value"]
+		if !ok && len(p.Fields) == 1 {
+			for _, v := range p.Fields {
+				value, ok = v, true
+			}
+		}
+
+		if !ok {
+			http.Error(w, fmt.Sprintf("missing value field: %s", p.Measurement), http.StatusBadRequest)
+			return
+		}
+
+		batch[uuid] = append(batch[uuid], volkszaehler.Tuple{
+			Timestamp: p.Time.UnixNano() / int64(time.Millisecond),
+			Value:     value,
+		})
+	}
+
+	for _, tuples := range batch {
+		if err := validateTuples(tuples); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	server.writeBatch(w, r, batch)
+}
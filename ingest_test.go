@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// fakeWriter fails writes for configured channels
+type fakeWriter struct {
+	errors  map[string]error
+	written map[string][]volkszaehler.Tuple
+}
+
+func (w *fakeWriter) PostData(uuid string, tuples []volkszaehler.Tuple) (int, error) {
+	if err := w.errors[uuid]; err != nil {
+		return 0, err
+	}
+
+	w.written[uuid] = append(w.written[uuid], tuples...)
+
+	return len(tuples), nil
+}
+
+func TestIngestBufferFlush(t *testing.T) {
+	writer := &fakeWriter{
+		errors: map[string]error{
+			"rejected":    &volkszaehler.ExceptionError{Exception: volkszaehler.Exception{Message: "invalid uuid"}, StatusCode: 400},
+			"unavailable": &volkszaehler.ExceptionError{Exception: volkszaehler.Exception{Message: "database down"}, StatusCode: 500},
+			"offline":     errors.New("connection refused"),
+		},
+		written: make(map[string][]volkszaehler.Tuple),
+	}
+
+	var callbacks []string
+	buffer := newIngestBuffer(writer, 10, func(uuid string, tuples []volkszaehler.Tuple) {
+		callbacks = append(callbacks, uuid)
+	})
+
+	batch := map[string][]volkszaehler.Tuple{
+		"ok":          {{Timestamp: 1, Value: 1}},
+		"rejected":    {{Timestamp: 1, Value: 1}},
+		"unavailable": {{Timestamp: 1, Value: 1}},
+		"offline":     {{Timestamp: 1, Value: 1}},
+	}
+
+	if err := buffer.add(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := buffer.add(map[string][]volkszaehler.Tuple{"offline": {{Timestamp: 2, Value: 2}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer.flush()
+
+	if !reflect.DeepEqual(callbacks, []string{"ok"}) {
+		t.Errorf("expected written callback for ok only, got %v", callbacks)
+	}
+
+	if len(writer.written["ok"]) != 1 {
+		t.Errorf("expected ok to be written, got %v", writer.written)
+	}
+
+	// rejected data is dropped, unavailable data is kept for retry
+	expected := map[string][]volkszaehler.Tuple{
+		"unavailable": {{Timestamp: 1, Value: 1}},
+		"offline":     {{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}},
+	}
+
+	if !reflect.DeepEqual(buffer.pending, expected) {
+		t.Errorf("expected pending %v, got %v", expected, buffer.pending)
+	}
+
+	if buffer.size != 3 {
+		t.Errorf("expected size 3, got %d", buffer.size)
+	}
+
+	// retried data is written in order
+	writer.errors = nil
+	if err := buffer.add(map[string][]volkszaehler.Tuple{"offline": {{Timestamp: 3, Value: 3}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer.flush()
+
+	if len(buffer.pending) != 0 || buffer.size != 0 {
+		t.Errorf("expected empty buffer, got %v", buffer.pending)
+	}
+
+	if ts := writer.written["offline"]; len(ts) != 3 || ts[0].Timestamp != 1 || ts[2].Timestamp != 3 {
+		t.Errorf("unexpected retry order: %v", ts)
+	}
+}
+
+func TestIngestBufferLimit(t *testing.T) {
+	buffer := newIngestBuffer(&fakeWriter{written: make(map[string][]volkszaehler.Tuple)}, 2, nil)
+
+	if err := buffer.add(map[string][]volkszaehler.Tuple{"a": {{Timestamp: 1}, {Timestamp: 2}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := buffer.add(map[string][]volkszaehler.Tuple{"a": {{Timestamp: 3}}}); err != errIngestBufferFull {
+		t.Errorf("expected %v, got %v", errIngestBufferFull, err)
+	}
+
+	buffer.flush()
+
+	if err := buffer.add(map[string][]volkszaehler.Tuple{"a": {{Timestamp: 3}}}); err != nil {
+		t.Errorf("unexpected error after flush: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package interval
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var intervalRE = regexp.MustCompile(`^([+-]?)(\d+)\s*([a-z]+)$`)
+
+// Parse parses relative time intervals like 1d, -6h or 15min as used by
+// Graphite and group durations. A month is 30 days, a year 365 days.
+func Parse(s string) (time.Duration, error) {
+	match := intervalRE.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if match == nil {
+		return 0, fmt.Errorf("invalid interval: %s", s)
+	}
+
+	n, _ := strconv.ParseInt(match[2], 10, 64)
+
+	var unit time.Duration
+	switch match[3] {
+	case "s", "sec", "secs", "second", "seconds":
+		unit = time.Second
+	case "m", "min", "mins", "minute", "minutes":
+		unit = time.Minute
+	case "h", "hour", "hours":
+		unit = time.Hour
+	case "d", "day", "days":
+		unit = 24 * time.Hour
+	case "w", "week", "weeks":
+		unit = 7 * 24 * time.Hour
+	case "mon", "month", "months":
+		unit = 30 * 24 * time.Hour
+	case "y", "year", "years":
+		unit = 365 * 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("invalid interval unit: %s", s)
+	}
+
+	d := time.Duration(n) * unit
+	if match[1] == "-" {
+		d = -d
+	}
+
+	return d, nil
+}
@@ -0,0 +1,51 @@
+package interval
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tc := []struct {
+		s   string
+		d   time.Duration
+		err bool
+	}{
+		{"15s", 15 * time.Second, false},
+		{"15min", 15 * time.Minute, false},
+		{"15m", 15 * time.Minute, false},
+		{"2h", 2 * time.Hour, false},
+		{" 1 Day ", 24 * time.Hour, false},
+		{"1w", 7 * 24 * time.Hour, false},
+		{"1mon", 30 * 24 * time.Hour, false},
+		{"1y", 365 * 24 * time.Hour, false},
+		{"-6h", -6 * time.Hour, false},
+		{"+6h", 6 * time.Hour, false},
+		{"0d", 0, false},
+		{"", 0, true},
+		{"h", 0, true},
+		{"1.5h", 0, true},
+		{"1x", 0, true},
+		{"1M", time.Minute, false}, // case insensitive
+	}
+
+	for _, c := range tc {
+		d, err := Parse(c.s)
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected error", c.s)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.s, err)
+			continue
+		}
+
+		if d != c.d {
+			t.Errorf("%q: expected %v, got %v", c.s, c.d, d)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/andig/gravo/volkszaehler"
@@ -14,36 +19,194 @@ var (
 	version = "development"
 	commit  = "unknown commit"
 	timeout = 30 * time.Second
+
+	shutdownTimeout = 5 * time.Second
 )
 
 var apiURL = flag.String("api", "https://demo.volkszaehler.org/middleware.php", "volkszaehler api url")
 var apiTimeout = flag.Duration("timeout", timeout, "volkszaehler api request timeout")
+var refresh = flag.Duration("refresh", 15*time.Minute, "entity refresh interval, 0 to disable")
+var private = flag.String("private", "", "comma-separated private channel uuids to include in search")
+var ingest = flag.String("ingest", "", "comma-separated uuid:token pairs of channels accepting writes")
+var ingestInterval = flag.Duration("ingest-interval", 10*time.Second, "interval for forwarding written data")
+var rollups = flag.String("rollups", "", "rollup store file for hour/day/month grouped queries, empty to disable")
+var rollupGrace = flag.Duration("rollup-grace", time.Hour, "delay before completed periods are stored to allow for late data")
+var prewarm = flag.String("prewarm", "", "json file with queries or grafana dashboard to execute periodically")
+var prewarmInterval = flag.Duration("prewarm-interval", 15*time.Minute, "prewarm interval")
+var push = flag.String("push", "", "volkszaehler push server websocket url, e.g. ws://localhost:8082")
+var mqttBroker = flag.String("mqtt", "", "mqtt broker url, e.g. tcp://localhost:1883")
+var mqttUser = flag.String("mqtt-user", "", "mqtt user")
+var mqttPassword = flag.String("mqtt-password", "", "mqtt password")
+var mqttTopic = flag.String("mqtt-topic", "volkszaehler", "mqtt base topic")
+var mqttQos = flag.Int("mqtt-qos", 0, "mqtt qos")
+var mqttChannels = flag.String("mqtt-channels", "", "comma-separated uuids or title paths to publish, default all public channels")
+var mqttInterval = flag.Duration("mqtt-interval", time.Minute, "mqtt publish interval, 0 to disable publishing")
+var mqttDiscovery = flag.String("mqtt-discovery", "homeassistant", "home assistant discovery prefix, empty to disable")
+var mqttSubscribe = flag.String("mqtt-subscribe", "", "comma-separated topic=uuid[:field] mappings of topics written into volkszaehler")
 var url = flag.String("url", "0.0.0.0:8000", "listening address")
 var verbose = flag.Bool("verbose", false, "verbose logging")
 var help = flag.Bool("help", false, "help")
 
-func main() {
-	log.Printf("Running gravo %s (%s)", version, commit)
+func init() {
+	flag.Var(weekdayValue{&firstWeekday}, "week-start", "first day of week for week grouping")
+}
 
+func main() {
+	flag.Usage = usage
 	flag.Parse()
 
 	if *help {
-		flag.PrintDefaults()
+		usage()
 		os.Exit(0)
 	}
 
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Arg(0), flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	log.Printf("Running gravo %s (%s)", version, commit)
+
+	if isPlugin() {
+		if err := servePlugin(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	httpClient := http.Client{Timeout: *apiTimeout}
 	client := volkszaehler.NewClient(*apiURL, &httpClient, *verbose)
-	server := newServer(client)
+	server := newServer(client, *refresh, privateUUIDs(*private))
+
+	if *ingest != "" {
+		tokens, err := ingestTokens(*ingest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := server.enableIngest(tokens, *ingestInterval); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *rollups != "" {
+		if err := server.enableRollups(*rollups, *rollupGrace); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *push != "" {
+		server.enablePush(*push)
+	}
+
+	if *mqttBroker != "" {
+		opts := mqttOptions{
+			broker:   *mqttBroker,
+			user:     *mqttUser,
+			password: *mqttPassword,
+			qos:      *mqttQos,
+			topic:    *mqttTopic,
+		}
+
+		subscriptions, err := mqttSubscriptions(*mqttSubscribe)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := server.enableMqtt(opts, *mqttDiscovery, privateUUIDs(*mqttChannels), *mqttInterval, subscriptions); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *prewarm != "" {
+		queries, err := loadPrewarmQueries(*prewarm)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.enablePrewarm(queries, *prewarmInterval)
+	}
 
-	http.HandleFunc("/", handler(server.rootHandler, *verbose))
-	http.HandleFunc("/query", handler(server.queryHandler, *verbose))
-	http.HandleFunc("/search", handler(server.searchHandler, *verbose))
-	http.HandleFunc("/annotations", handler(server.annotationsHandler, *verbose))
-	http.HandleFunc("/tag-keys", handler(server.tagKeysHandler, *verbose))
-	http.HandleFunc("/tag-values", handler(server.tagValuesHandler, *verbose))
+	registerHandlers(http.DefaultServeMux, server)
 
-	if err := http.ListenAndServe(*url, nil); err != nil {
+	srv := &http.Server{Addr: *url}
+	stopped := make(chan struct{})
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		log.Println("shutting down")
+
+		// complete pending requests, open streams are closed after timeout
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+
+		close(stopped)
+	}()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+
+	<-stopped
+
+	// flush buffered writes
+	server.Close()
+}
+
+// registerHandlers registers the http endpoints
+func registerHandlers(mux *http.ServeMux, server *Server) {
+	mux.HandleFunc("/", handler(server.rootHandler, *verbose))
+	mux.HandleFunc("/query", handler(server.queryHandler, *verbose))
+	mux.HandleFunc("/search", handler(server.searchHandler, *verbose))
+	mux.HandleFunc("/metrics", handler(server.metricsHandler, *verbose))
+	mux.HandleFunc("/metric-payload-options", handler(server.metricPayloadOptionsHandler, *verbose))
+	mux.HandleFunc("/variable", handler(server.variableHandler, *verbose))
+	mux.HandleFunc("/annotations", handler(server.annotationsHandler, *verbose))
+	mux.HandleFunc("/tag-keys", handler(server.tagKeysHandler, *verbose))
+	mux.HandleFunc("/tag-values", handler(server.tagValuesHandler, *verbose))
+
+	// live data
+	mux.HandleFunc("/stream", handler(server.streamHandler, *verbose))
+
+	// query prewarming
+	mux.HandleFunc("/prewarm", handler(server.prewarmHandler, *verbose))
+
+	// data export
+	mux.HandleFunc("/export", handler(server.exportHandler, *verbose))
+
+	// data ingest
+	mux.HandleFunc("/ingest/", handler(server.ingestHandler, *verbose))
+
+	// InfluxDB compatibility
+	mux.HandleFunc("/influx/ping", handler(server.influxPingHandler, *verbose))
+	mux.HandleFunc("/influx/query", handler(server.influxQueryHandler, *verbose))
+	mux.HandleFunc("/influx/write", handler(server.influxWriteHandler, *verbose))
+
+	// Prometheus remote read
+	mux.HandleFunc("/prometheus/read", handler(server.prometheusReadHandler, *verbose))
+
+	// Graphite compatibility
+	mux.HandleFunc("/graphite/render", handler(server.graphiteRenderHandler, *verbose))
+	mux.HandleFunc("/graphite/metrics/find", handler(server.graphiteFindHandler, *verbose))
+}
+
+// privateUUIDs splits the comma-separated list of private uuids
+func privateUUIDs(list string) []string {
+	res := make([]string, 0)
+
+	for _, uuid := range strings.Split(list, ",") {
+		if uuid = strings.TrimSpace(uuid); uuid != "" {
+			res = append(res, uuid)
+		}
+	}
+
+	return res
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/andig/gravo/grafana"
+)
+
+// prognosisPeriods are the supported values of the period payload field
+var prognosisPeriods = []string{"day", "week", "month", "year"}
+
+func payloadOptions(values ...string) []grafana.MetricPayloadOption {
+	res := make([]grafana.MetricPayloadOption, 0, len(values))
+	for _, value := range values {
+		res = append(res, grafana.MetricPayloadOption{Label: value, Value: value})
+	}
+
+	return res
+}
+
+// metricPayloads describes the grafana.TargetData payload fields
+func metricPayloads() []grafana.MetricPayload {
+	return []grafana.MetricPayload{
+		{
+			Label:   "Context",
+			Name:    "context",
+			Type:    "select",
+			Options: payloadOptions("data", "prognosis"),
+		},
+		{
+			Label:   "Group",
+			Name:    "group",
+			Type:    "select",
+			Options: payloadOptions(granularities...),
+		},
+		{
+			Label:   "Options",
+			Name:    "options",
+			Type:    "select",
+			Options: payloadOptions("raw", "consumption"),
+		},
+		{
+			Label:       "Tuples",
+			Name:        "tuples",
+			Type:        "input",
+			Placeholder: "number of tuples, e.g. 500",
+		},
+		{
+			Label:       "Aggregate",
+			Name:        "aggregate",
+			Type:        "input",
+			Placeholder: "e.g. movingAverage(15m)|integral(1h)",
+		},
+		{
+			Label:   "Downsample",
+			Name:    "downsample",
+			Type:    "select",
+			Options: payloadOptions(downsamplers...),
+		},
+		{
+			Label:       "Name",
+			Name:        "name",
+			Type:        "input",
+			Placeholder: "channel title",
+		},
+		{
+			Label:   "Period",
+			Name:    "period",
+			Type:    "select",
+			Options: payloadOptions(prognosisPeriods...),
+		},
+	}
+}
+
+func (server *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	mr := grafana.MetricsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&mr); err != nil {
+		log.Printf("json decode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json decode failed: %v", err), http.StatusBadRequest)
+
+		return
+	}
+
+	resp := server.executeMetrics()
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+func (server *Server) executeMetrics() []grafana.MetricsResponse {
+	entities := server.getPublicEntites()
+	// limit capacity to not modify the cached entities
+	entities = append(entities[:len(entities):len(entities)], server.getPrivateEntities()...)
+
+	payloads := metricPayloads()
+
+	res := make([]grafana.MetricsResponse, 0, len(entities))
+	for _, entity := range entities {
+		res = append(res, grafana.MetricsResponse{
+			Label:    entity.Title,
+			Value:    entity.UUID,
+			Payloads: payloads,
+		})
+	}
+
+	return res
+}
+
+func (server *Server) metricPayloadOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	pr := grafana.MetricPayloadOptionsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+		log.Printf("json decode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json decode failed: %v", err), http.StatusBadRequest)
+
+		return
+	}
+
+	resp := []grafana.MetricPayloadOption{}
+	for _, payload := range metricPayloads() {
+		if payload.Name == pr.Name && payload.Options != nil {
+			resp = payload.Options
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+
+		return
+	}
+}
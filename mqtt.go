@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andig/gravo/volkszaehler"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttTimeout is the maximum time for publishing a message
+const mqttTimeout = 10 * time.Second
+
+// mqttPublisher publishes retained messages. It is implemented by the MQTT
+// client and can be replaced by a broker stand-in.
+type mqttPublisher interface {
+	Publish(topic string, payload []byte) error
+}
+
+// pahoPublisher publishes via the paho MQTT client
+type pahoPublisher struct {
+	client mqtt.Client
+	qos    byte
+}
+
+// Publish publishes a retained message and waits for completion
+func (p *pahoPublisher) Publish(topic string, payload []byte) error {
+	token := p.client.Publish(topic, p.qos, true, payload)
+	if !token.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("publish timeout: %s", topic)
+	}
+
+	return token.Error()
+}
+
+// mqttOptions are the MQTT connection options
+type mqttOptions struct {
+	broker   string
+	user     string
+	password string
+	qos      int
+	topic    string
+}
+
+// mqttClientID returns a random client id. Process ids are not unique
+// across containers.
+func mqttClientID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("gravo-%d", time.Now().UnixNano())
+	}
+
+	return "gravo-" + hex.EncodeToString(b)
+}
+
+// newMqttClient creates an automatically reconnecting MQTT client. The
+// status topic is set to online when connected and offline otherwise.
+// The connection is retried in background until established.
+func newMqttClient(opts mqttOptions, onConnect func(mqtt.Client)) (mqtt.Client, error) {
+	if opts.qos < 0 || opts.qos > 2 {
+		return nil, fmt.Errorf("invalid qos: %d", opts.qos)
+	}
+
+	status := opts.topic + "/status"
+
+	options := mqtt.NewClientOptions().
+		AddBroker(opts.broker).
+		SetClientID(mqttClientID()).
+		SetUsername(opts.user).
+		SetPassword(opts.password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(status, "offline", byte(opts.qos), true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			log.Printf("mqtt connected: %s", opts.broker)
+			client.Publish(status, byte(opts.qos), true, "online")
+			if onConnect != nil {
+				onConnect(client)
+			}
+		}).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			log.Printf("mqtt connection lost: %v", err)
+		})
+
+	return mqtt.NewClient(options), nil
+}
+
+// haUnits maps Grafana units to Home Assistant units and device classes
+var haUnits = map[string][2]string{
+	"watt":        {"W", "power"},
+	"celsius":     {"°C", "temperature"},
+	"humidity":    {"%", "humidity"},
+	"pressurehpa": {"hPa", "pressure"},
+	"volt":        {"V", "voltage"},
+	"amp":         {"A", "current"},
+	"hertz":       {"Hz", "frequency"},
+}
+
+// haDiscovery is the Home Assistant MQTT sensor discovery payload
+// https://www.home-assistant.io/integrations/sensor.mqtt/
+type haDiscovery struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	Unit              string   `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	StateClass        string   `json:"state_class"`
+	Device            haDevice `json:"device"`
+}
+
+// haDevice groups all gravo sensors in Home Assistant
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	SWVersion    string   `json:"sw_version"`
+}
+
+// mqttBridge periodically publishes the latest values of the selected
+// channels
+type mqttBridge struct {
+	server    *Server
+	publisher mqttPublisher
+	topic     string
+	discovery string   // Home Assistant discovery prefix, empty to disable
+	channels  []string // uuids or title paths, empty for all public channels
+
+	mux        sync.Mutex // guards discovered
+	discovered map[string]bool
+}
+
+func newMqttBridge(server *Server, publisher mqttPublisher, topic, discovery string, channels []string) *mqttBridge {
+	return &mqttBridge{
+		server:     server,
+		publisher:  publisher,
+		topic:      topic,
+		discovery:  strings.TrimSuffix(discovery, "/"),
+		channels:   channels,
+		discovered: make(map[string]bool),
+	}
+}
+
+// channelTopic converts an entity title path into a topic below base removing
+// MQTT wildcards and whitespace
+func channelTopic(base, title string) string {
+	topic := strings.NewReplacer("+", "", "#", "", " ", "_").Replace(title)
+	return base + "/" + strings.Trim(topic, "/")
+}
+
+// reset forces discovery payloads to be published again, e.g. after
+// reconnecting to the broker
+func (bridge *mqttBridge) reset() {
+	bridge.mux.Lock()
+	bridge.discovered = make(map[string]bool)
+	bridge.mux.Unlock()
+}
+
+// entities returns the selected channels
+func (bridge *mqttBridge) entities() []volkszaehler.Entity {
+	if len(bridge.channels) == 0 {
+		return bridge.server.getPublicEntites()
+	}
+
+	res := make([]volkszaehler.Entity, 0, len(bridge.channels))
+	for _, channel := range bridge.channels {
+		if entity, ok := bridge.server.entity(bridge.server.resolveUUID(channel)); ok {
+			res = append(res, entity)
+		}
+	}
+
+	return res
+}
+
+// publishDiscovery publishes the Home Assistant discovery payload once
+func (bridge *mqttBridge) publishDiscovery(entity volkszaehler.Entity, topic string) error {
+	bridge.mux.Lock()
+	done := bridge.discovered[entity.UUID]
+	bridge.mux.Unlock()
+
+	if done || bridge.discovery == "" {
+		return nil
+	}
+
+	unit := haUnits[entityUnit(entity, "")]
+	payload, err := json.Marshal(haDiscovery{
+		Name:              entity.Title,
+		UniqueID:          "gravo_" + entity.UUID,
+		StateTopic:        topic,
+		AvailabilityTopic: bridge.topic + "/status",
+		Unit:              unit[0],
+		DeviceClass:       unit[1],
+		StateClass:        "measurement",
+		Device: haDevice{
+			Identifiers:  []string{"gravo"},
+			Name:         "Volkszaehler",
+			Manufacturer: "gravo",
+			SWVersion:    version,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	configTopic := fmt.Sprintf("%s/sensor/gravo_%s/config", bridge.discovery, entity.UUID)
+	if err := bridge.publisher.Publish(configTopic, payload); err != nil {
+		return err
+	}
+
+	bridge.mux.Lock()
+	bridge.discovered[entity.UUID] = true
+	bridge.mux.Unlock()
+
+	return nil
+}
+
+// publish publishes the latest value of all channels. Values older than
+// the lookback period are not published.
+func (bridge *mqttBridge) publish(lookback time.Duration) {
+	for _, entity := range bridge.entities() {
+		topic := channelTopic(bridge.topic, entity.Title)
+
+		if err := bridge.publishDiscovery(entity, topic); err != nil {
+			log.Printf("mqtt discovery failed: %v", err)
+		}
+
+		tuple, ok, err := bridge.server.latestTuple(entity.UUID, lookback)
+		if err != nil {
+			log.Printf("api call failed: %v", err)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		payload := strconv.FormatFloat(tuple.Value, 'f', -1, 64)
+		if err := bridge.publisher.Publish(topic, []byte(payload)); err != nil {
+			log.Printf("mqtt publish failed: %v", err)
+		}
+	}
+}
+
+// run publishes periodically until done is closed. Publishing is disabled
+// if interval is not positive.
+func (bridge *mqttBridge) run(interval time.Duration, done chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// values are queried for twice the interval to not miss slow channels
+	bridge.publish(2 * interval)
+
+	for {
+		select {
+		case <-ticker.C:
+			bridge.publish(2 * interval)
+		case <-done:
+			return
+		}
+	}
+}
+
+// enableMqtt connects to the MQTT broker. If interval is positive, the
+// latest values of the given channels are published every interval.
+// Values received on subscribed topics are written into the middleware.
+func (server *Server) enableMqtt(opts mqttOptions, discovery string, channels []string, interval time.Duration, subscriptions map[string]mqttSubscription) error {
+	opts.topic = strings.TrimSuffix(opts.topic, "/")
+	bridge := newMqttBridge(server, nil, opts.topic, discovery, channels)
+
+	var writer volkszaehler.Writer
+	if len(subscriptions) > 0 {
+		var err error
+		if writer, err = server.writer(); err != nil {
+			return err
+		}
+	}
+
+	subscriber := &mqttSubscriber{
+		buffer:        newIngestBuffer(writer, ingestLimit, server.invalidateRollups),
+		subscriptions: subscriptions,
+		qos:           byte(opts.qos),
+	}
+
+	client, err := newMqttClient(opts, func(client mqtt.Client) {
+		bridge.reset()
+		subscriber.subscribe(client)
+	})
+	if err != nil {
+		return err
+	}
+
+	bridge.publisher = &pahoPublisher{client: client, qos: byte(opts.qos)}
+
+	token := client.Connect()
+
+	if interval > 0 {
+		go func() {
+			// start publishing when connected
+			token.Wait()
+			bridge.run(interval, server.done)
+		}()
+	}
+
+	if len(subscriptions) > 0 {
+		server.flushes.Add(1)
+		go func() {
+			subscriber.buffer.run(*ingestInterval, server.done)
+			server.flushes.Done()
+		}()
+	}
+
+	// the will is not sent on regular disconnect
+	server.flushes.Add(1)
+	go func() {
+		<-server.done
+		client.Publish(opts.topic+"/status", byte(opts.qos), true, "offline").WaitTimeout(mqttTimeout)
+		client.Disconnect(250)
+		server.flushes.Done()
+	}()
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andig/gravo/volkszaehler"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeAPI is a middleware stand-in returning fixed entities and data
+type fakeAPI struct {
+	entities []volkszaehler.Entity
+	data     map[string][]volkszaehler.Tuple
+}
+
+func (api *fakeAPI) Get(endpoint string) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (api *fakeAPI) Post(endpoint string, payload string) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (api *fakeAPI) QueryPublicEntities() ([]volkszaehler.Entity, error) {
+	return api.entities, nil
+}
+
+func (api *fakeAPI) QueryEntity(uuid string) (volkszaehler.Entity, error) {
+	for _, entity := range api.entities {
+		if entity.UUID == uuid {
+			return entity, nil
+		}
+	}
+	return volkszaehler.Entity{}, errors.New("api exception: invalid uuid")
+}
+
+func (api *fakeAPI) QueryData(uuid string, from time.Time, to time.Time, group string, options string, tuples int) ([]volkszaehler.Tuple, error) {
+	return api.data[uuid], nil
+}
+
+func (api *fakeAPI) QueryPrognosis(uuid string, period string) (volkszaehler.Prognosis, error) {
+	return volkszaehler.Prognosis{}, errors.New("not implemented")
+}
+
+func (api *fakeAPI) PostData(uuid string, tuples []volkszaehler.Tuple) (int, error) {
+	return len(tuples), nil
+}
+
+// fakePublisher records published messages by topic
+type fakePublisher struct {
+	mux      sync.Mutex
+	messages map[string][]string
+}
+
+func (p *fakePublisher) Publish(topic string, payload []byte) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.messages == nil {
+		p.messages = make(map[string][]string)
+	}
+	p.messages[topic] = append(p.messages[topic], string(payload))
+
+	return nil
+}
+
+// fakeMqttClient records the retained flag of published messages
+type fakeMqttClient struct {
+	mqtt.Client
+	retained map[string]bool
+}
+
+func (c *fakeMqttClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.retained[topic] = retained
+	return &mqtt.DummyToken{}
+}
+
+func newMqttTestServer() *Server {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	api := &fakeAPI{
+		entities: []volkszaehler.Entity{
+			{UUID: "g1", Type: "group", Title: "House", Children: []volkszaehler.Entity{
+				{UUID: "c1", Type: "power", Title: "Grid"},
+				{UUID: "c2", Type: "temperature", Title: "Living Room"},
+			}},
+			{UUID: "c3", Type: "power", Title: "Garage"},
+		},
+		data: map[string][]volkszaehler.Tuple{
+			"c1": {{Timestamp: now - 2000, Value: 100}, {Timestamp: now - 1000, Value: 120.5}},
+			"c2": {{Timestamp: now - 1000, Value: 21.5}, {Timestamp: now, Null: true}},
+		},
+	}
+
+	return newServer(api, 0, nil)
+}
+
+func TestChannelTopic(t *testing.T) {
+	tc := []struct {
+		title, topic string
+	}{
+		{"House/Grid", "vz/House/Grid"},
+		{"House/Living Room", "vz/House/Living_Room"},
+		{"/House/Grid+#/", "vz/House/Grid"},
+	}
+
+	for _, c := range tc {
+		if topic := channelTopic("vz", c.title); topic != c.topic {
+			t.Errorf("%s: expected %s, got %s", c.title, c.topic, topic)
+		}
+	}
+}
+
+func TestMqttBridgePublish(t *testing.T) {
+	server := newMqttTestServer()
+	defer server.Close()
+
+	pub := &fakePublisher{}
+	bridge := newMqttBridge(server, pub, "vz", "homeassistant/", nil)
+
+	bridge.publish(time.Minute)
+	bridge.publish(time.Minute)
+
+	expected := map[string][]string{
+		"vz/House/Grid":        {"120.5", "120.5"},
+		"vz/House/Living_Room": {"21.5", "21.5"},
+	}
+
+	for topic, values := range expected {
+		if got := pub.messages[topic]; strings.Join(got, ",") != strings.Join(values, ",") {
+			t.Errorf("%s: expected %v, got %v", topic, values, got)
+		}
+	}
+
+	// channels without recent value are not published
+	if got, ok := pub.messages["vz/Garage"]; ok {
+		t.Errorf("vz/Garage: unexpected %v", got)
+	}
+
+	// discovery is published once per channel
+	for _, uuid := range []string{"c1", "c2", "c3"} {
+		if got := pub.messages["homeassistant/sensor/gravo_"+uuid+"/config"]; len(got) != 1 {
+			t.Errorf("%s: expected single discovery message, got %d", uuid, len(got))
+		}
+	}
+
+	var discovery haDiscovery
+	if err := json.Unmarshal([]byte(pub.messages["homeassistant/sensor/gravo_c1/config"][0]), &discovery); err != nil {
+		t.Fatal(err)
+	}
+
+	if discovery.StateTopic != "vz/House/Grid" || discovery.AvailabilityTopic != "vz/status" ||
+		discovery.UniqueID != "gravo_c1" || discovery.Unit != "W" || discovery.DeviceClass != "power" {
+		t.Errorf("unexpected discovery payload: %+v", discovery)
+	}
+
+	// discovery is published again after reconnect
+	bridge.reset()
+	bridge.publish(time.Minute)
+
+	if got := pub.messages["homeassistant/sensor/gravo_c1/config"]; len(got) != 2 {
+		t.Errorf("expected discovery after reset, got %d messages", len(got))
+	}
+}
+
+func TestMqttBridgeChannels(t *testing.T) {
+	server := newMqttTestServer()
+	defer server.Close()
+
+	pub := &fakePublisher{}
+	bridge := newMqttBridge(server, pub, "vz", "", []string{"House/Grid", "unknown"})
+	bridge.publish(time.Minute)
+
+	if len(pub.messages) != 1 || len(pub.messages["vz/House/Grid"]) != 1 {
+		t.Errorf("expected single channel without discovery, got %v", pub.messages)
+	}
+}
+
+func TestMqttBridgeRunDisabled(t *testing.T) {
+	server := newMqttTestServer()
+	defer server.Close()
+
+	pub := &fakePublisher{}
+	bridge := newMqttBridge(server, pub, "vz", "", nil)
+
+	// must not panic or block
+	bridge.run(0, server.done)
+
+	if len(pub.messages) != 0 {
+		t.Errorf("unexpected messages: %v", pub.messages)
+	}
+}
+
+func TestPahoPublisherRetained(t *testing.T) {
+	client := &fakeMqttClient{retained: make(map[string]bool)}
+	pub := &pahoPublisher{client: client}
+
+	if err := pub.Publish("vz/House/Grid", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !client.retained["vz/House/Grid"] {
+		t.Error("expected retained message")
+	}
+}
+
+func TestMqttClientAvailability(t *testing.T) {
+	client, err := newMqttClient(mqttOptions{broker: "tcp://localhost:1883", topic: "vz", qos: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := client.OptionsReader()
+
+	if !opts.WillEnabled() || opts.WillTopic() != "vz/status" || string(opts.WillPayload()) != "offline" || !opts.WillRetained() {
+		t.Errorf("unexpected will: %s %s retained %v", opts.WillTopic(), opts.WillPayload(), opts.WillRetained())
+	}
+
+	if !strings.HasPrefix(opts.ClientID(), "gravo-") {
+		t.Errorf("unexpected client id: %s", opts.ClientID())
+	}
+
+	other, _ := newMqttClient(mqttOptions{broker: "tcp://localhost:1883", topic: "vz"}, nil)
+	if id := other.OptionsReader(); id.ClientID() == opts.ClientID() {
+		t.Errorf("client ids not unique: %s", opts.ClientID())
+	}
+
+	if _, err := newMqttClient(mqttOptions{qos: 3}, nil); err == nil {
+		t.Error("expected invalid qos error")
+	}
+}
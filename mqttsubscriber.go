@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andig/gravo/volkszaehler"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttSubscription maps a topic to the channel its values are written to
+type mqttSubscription struct {
+	uuid  string
+	field string // json payload field, value if empty
+}
+
+// mqttSubscriptions parses the comma-separated list of topic=uuid[:field]
+// entries
+func mqttSubscriptions(list string) (map[string]mqttSubscription, error) {
+	res := make(map[string]mqttSubscription)
+
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		segments := strings.SplitN(entry, "=", 2)
+		if len(segments) != 2 || segments[0] == "" || segments[1] == "" {
+			return nil, fmt.Errorf("invalid mqtt subscription: %s", entry)
+		}
+
+		sub := mqttSubscription{uuid: segments[1]}
+		if idx := strings.Index(sub.uuid, ":"); idx >= 0 {
+			sub.uuid, sub.field = sub.uuid[:idx], sub.uuid[idx+1:]
+		}
+
+		res[segments[0]] = sub
+	}
+
+	return res, nil
+}
+
+// mqttTuple converts a message payload into a tuple. Payloads are plain
+// numbers, booleans like on/off or JSON objects with numeric field and
+// optional timestamp in milliseconds.
+func mqttTuple(payload []byte, field string, now time.Time) (volkszaehler.Tuple, error) {
+	tuple := volkszaehler.Tuple{
+		Timestamp: now.UnixNano() / int64(time.Millisecond),
+	}
+
+	s := strings.TrimSpace(string(payload))
+
+	switch strings.ToLower(s) {
+	case "on", "true":
+		tuple.Value = 1
+		return tuple, nil
+	case "off", "false":
+		return tuple, nil
+	}
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		tuple.Value = f
+		return tuple, validateTuples([]volkszaehler.Tuple{tuple})
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &obj); err != nil {
+		return tuple, fmt.Errorf("invalid payload: %s", s)
+	}
+
+	if field == "" {
+		field = "value"
+	}
+
+	raw, ok := obj[field]
+	if !ok {
+		return tuple, fmt.Errorf("missing field %s: %s", field, s)
+	}
+
+	// values may be encoded as number or string
+	var value json.Number
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return tuple, fmt.Errorf("invalid value: %s", raw)
+	}
+
+	f, err := value.Float64()
+	if err != nil {
+		return tuple, fmt.Errorf("invalid value: %s", raw)
+	}
+	tuple.Value = f
+
+	if ts, ok := obj["timestamp"]; ok {
+		if err := json.Unmarshal(ts, &tuple.Timestamp); err != nil {
+			return tuple, fmt.Errorf("invalid timestamp: %s", ts)
+		}
+	}
+
+	return tuple, validateTuples([]volkszaehler.Tuple{tuple})
+}
+
+// mqttTimestamped returns true if the payload is a JSON object with
+// timestamp
+func mqttTimestamped(payload []byte) bool {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &obj); err != nil {
+		return false
+	}
+
+	_, ok := obj["timestamp"]
+	return ok
+}
+
+// mqttSubscriber writes values received on subscribed topics into the
+// middleware
+type mqttSubscriber struct {
+	buffer        *ingestBuffer
+	subscriptions map[string]mqttSubscription
+	qos           byte
+}
+
+// subscribe subscribes to all topics. It must be called whenever the
+// client has (re)connected.
+func (subscriber *mqttSubscriber) subscribe(client mqtt.Client) {
+	for topic, sub := range subscriber.subscriptions {
+		sub := sub
+
+		token := client.Subscribe(topic, subscriber.qos, func(_ mqtt.Client, msg mqtt.Message) {
+			subscriber.receive(sub, msg)
+		})
+
+		go func(topic string) {
+			if token.Wait(); token.Error() != nil {
+				log.Printf("mqtt subscribe failed: %s: %v", topic, token.Error())
+			}
+		}(topic)
+	}
+}
+
+// receive buffers the message value for writing. Retained messages are
+// delivered again on every (re)connect and ignored unless they carry their
+// own timestamp.
+func (subscriber *mqttSubscriber) receive(sub mqttSubscription, msg mqtt.Message) {
+	if msg.Retained() && !mqttTimestamped(msg.Payload()) {
+		return
+	}
+
+	tuple, err := mqttTuple(msg.Payload(), sub.field, time.Now())
+	if err != nil {
+		log.Printf("mqtt message ignored: %s: %v", msg.Topic(), err)
+		return
+	}
+
+	batch := map[string][]volkszaehler.Tuple{
+		sub.uuid: {tuple},
+	}
+
+	if err := subscriber.buffer.add(batch); err != nil {
+		log.Printf("mqtt message dropped: %s: %v", msg.Topic(), err)
+	}
+}
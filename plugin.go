@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/andig/gravo/grafana"
+	"github.com/andig/gravo/volkszaehler"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/grpcplugin"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// isPlugin checks if gravo has been started by Grafana as backend plugin
+func isPlugin() bool {
+	return os.Getenv(grpcplugin.MagicCookieKey) == grpcplugin.MagicCookieValue
+}
+
+// pluginSettings are the datasource settings configured in Grafana
+type pluginSettings struct {
+	Private string `json:"private"`
+}
+
+// pluginInstance is a gravo server per Grafana datasource
+type pluginInstance struct {
+	server *Server
+	mux    *http.ServeMux
+}
+
+// Dispose stops the instance when datasource settings have changed
+func (instance *pluginInstance) Dispose() {
+	instance.server.Close()
+}
+
+// plugin implements the Grafana backend datasource plugin protocol
+type plugin struct {
+	im instancemgmt.InstanceManager
+}
+
+// servePlugin serves gravo as Grafana backend plugin via gRPC
+func servePlugin() error {
+	p := &plugin{
+		im: datasource.NewInstanceManager(newPluginInstance),
+	}
+
+	return datasource.Serve(datasource.ServeOpts{
+		QueryDataHandler:    p,
+		CheckHealthHandler:  p,
+		StreamHandler:       p,
+		CallResourceHandler: httpadapter.New(http.HandlerFunc(p.resourceHandler)),
+	})
+}
+
+func newPluginInstance(settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+	var ps pluginSettings
+	if len(settings.JSONData) > 0 {
+		if err := json.Unmarshal(settings.JSONData, &ps); err != nil {
+			return nil, err
+		}
+	}
+
+	url := settings.URL
+	if url == "" {
+		url = *apiURL
+	}
+
+	httpClient := http.Client{Timeout: *apiTimeout}
+	client := volkszaehler.NewClient(url, &httpClient, *verbose)
+	server := newServer(client, *refresh, privateUUIDs(ps.Private))
+
+	mux := http.NewServeMux()
+	registerHandlers(mux, server)
+
+	return &pluginInstance{
+		server: server,
+		mux:    mux,
+	}, nil
+}
+
+func (p *plugin) instance(pluginContext backend.PluginContext) (*pluginInstance, error) {
+	instance, err := p.im.Get(pluginContext)
+	if err != nil {
+		return nil, err
+	}
+
+	return instance.(*pluginInstance), nil
+}
+
+// resourceHandler serves the http endpoints of the datasource instance,
+// e.g. for metric search
+func (p *plugin) resourceHandler(w http.ResponseWriter, r *http.Request) {
+	instance, err := p.instance(httpadapter.PluginConfigFromContext(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	instance.mux.ServeHTTP(w, r)
+}
+
+// CheckHealth verifies that the middleware is reachable
+func (p *plugin) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	instance, err := p.instance(req.PluginContext)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := instance.server.api.QueryPublicEntities(); err != nil {
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: fmt.Sprintf("api call failed: %v", err),
+		}, nil
+	}
+
+	return &backend.CheckHealthResult{
+		Status:  backend.HealthStatusOk,
+		Message: "ok",
+	}, nil
+}
+
+// QueryData executes the queries as data frames
+func (p *plugin) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	instance, err := p.instance(req.PluginContext)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := backend.NewQueryDataResponse()
+
+	for _, q := range req.Queries {
+		var target grafana.Target
+		if err := json.Unmarshal(q.JSON, &target); err != nil {
+			resp.Responses[q.RefID] = backend.DataResponse{Error: err}
+			continue
+		}
+
+		if target.Target == "" {
+			resp.Responses[q.RefID] = backend.DataResponse{Error: errors.New("missing target")}
+			continue
+		}
+
+		qr := grafana.QueryRequest{
+			Range: grafana.Range{
+				From: q.TimeRange.From,
+				To:   q.TimeRange.To,
+			},
+			IntervalMs:    q.Interval.Milliseconds(),
+			MaxDataPoints: int(q.MaxDataPoints),
+			Targets:       []grafana.Target{target},
+		}
+
+		qres := instance.server.executeQuery(qr)[0]
+		if qres.Error != nil {
+			resp.Responses[q.RefID] = backend.DataResponse{Error: qres.Error}
+			continue
+		}
+
+		frame := frameFromResponse(q.RefID, qres)
+
+		// Grafana subscribes to the live channel for streaming updates. The
+		// channel path is used as uuid by RunStream.
+		if target.Data.Stream && req.PluginContext.DataSourceInstanceSettings != nil {
+			uuid := instance.server.resolveUUID(target.Target)
+			frame.SetMeta(&data.FrameMeta{
+				Channel: fmt.Sprintf("ds/%s/%s", req.PluginContext.DataSourceInstanceSettings.UID, uuid),
+			})
+		}
+
+		resp.Responses[q.RefID] = backend.DataResponse{
+			Frames: data.Frames{frame},
+		}
+	}
+
+	return resp, nil
+}
+
+// frameFromResponse converts a query response into a time series data frame
+func frameFromResponse(refID string, qres grafana.QueryResponse) *data.Frame {
+	name := fmt.Sprintf("%v", qres.Target)
+
+	times := make([]time.Time, 0, len(qres.Datapoints))
+	values := make([]*float64, 0, len(qres.Datapoints))
+
+	for _, dp := range qres.Datapoints {
+		times = append(times, time.Unix(0, dp.Timestamp*int64(time.Millisecond)))
+
+		if dp.Null {
+			values = append(values, nil)
+		} else {
+			value := dp.Value
+			values = append(values, &value)
+		}
+	}
+
+	config := &data.FieldConfig{
+		DisplayNameFromDS: name,
+		Unit:              qres.Unit,
+	}
+
+	if qres.Decimals != nil && *qres.Decimals >= 0 {
+		config.SetDecimals(uint16(*qres.Decimals))
+	}
+
+	frame := data.NewFrame(name,
+		data.NewField("time", nil, times),
+		data.NewField("value", data.Labels(qres.Labels), values).SetConfig(config),
+	)
+	frame.RefID = refID
+
+	return frame
+}
+
+// SubscribeStream allows subscribing to the live channel of any channel uuid
+func (p *plugin) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	return &backend.SubscribeStreamResponse{
+		Status:       backend.SubscribeStreamStatusOK,
+		UseRunStream: true,
+	}, nil
+}
+
+// PublishStream rejects publishing as streams are read-only
+func (p *plugin) PublishStream(ctx context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{
+		Status: backend.PublishStreamStatusPermissionDenied,
+	}, nil
+}
+
+// RunStream sends new tuples of the channel identified by the stream path
+// until Grafana cancels the stream
+func (p *plugin) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender backend.StreamPacketSender) error {
+	instance, err := p.instance(req.PluginContext)
+	if err != nil {
+		return err
+	}
+
+	uuid := req.Path
+	target := grafana.Target{Target: uuid}
+
+	return instance.server.tail(ctx, uuid, streamInterval, func(qres grafana.QueryResponse) error {
+		instance.server.addSeriesMetadata(&qres, target)
+		if title := qres.Labels["title"]; title != "" {
+			qres.Target = title
+		}
+
+		b, err := data.FrameToJSON(frameFromResponse("", qres), true, true)
+		if err != nil {
+			return err
+		}
+
+		return sender.Send(&backend.StreamPacket{Data: b})
+	})
+}
@@ -0,0 +1,108 @@
+define(['react', '@grafana/data', '@grafana/runtime', '@grafana/ui'], function (React, data, runtime, ui) {
+  'use strict';
+
+  var h = React.createElement;
+
+  class DataSource extends runtime.DataSourceWithBackend {
+    // metric and template variable search
+    metricFindQuery(query) {
+      var target = runtime.getTemplateSrv().replace(query);
+      return this.postResource('search', { target: target }).then(function (res) {
+        return res.map(function (r) {
+          return { text: r.text, value: r.value };
+        });
+      });
+    }
+
+    applyTemplateVariables(query, scopedVars) {
+      var templateSrv = runtime.getTemplateSrv();
+      var payload = {};
+      Object.keys(query.payload || {}).forEach(function (key) {
+        var value = query.payload[key];
+        payload[key] = typeof value === 'string' ? templateSrv.replace(value, scopedVars) : value;
+      });
+
+      return Object.assign({}, query, {
+        target: templateSrv.replace(query.target || '', scopedVars),
+        payload: payload,
+      });
+    }
+
+    filterQuery(query) {
+      return !!query.target && !query.hide;
+    }
+  }
+
+  function ConfigEditor(props) {
+    var options = props.options;
+    var jsonData = options.jsonData || {};
+
+    return h('div', null,
+      h(ui.DataSourceHttpSettings, {
+        defaultUrl: 'http://localhost/middleware.php',
+        dataSourceConfig: options,
+        onChange: props.onOptionsChange,
+      }),
+      h('h3', { className: 'page-heading' }, 'Volkszaehler'),
+      h('div', { className: 'gf-form' },
+        h('span', { className: 'gf-form-label width-10' }, 'Private channels'),
+        h('input', {
+          className: 'gf-form-input width-30',
+          placeholder: 'comma-separated uuids',
+          value: jsonData.private || '',
+          onChange: function (e) {
+            props.onOptionsChange(Object.assign({}, options, {
+              jsonData: Object.assign({}, jsonData, { private: e.target.value }),
+            }));
+          },
+        })
+      )
+    );
+  }
+
+  function QueryEditor(props) {
+    var query = props.query;
+    var payload = React.useState(JSON.stringify(query.payload || {}));
+
+    return h('div', null,
+      h('div', { className: 'gf-form' },
+        h('span', { className: 'gf-form-label width-10' }, 'Channel'),
+        h('input', {
+          className: 'gf-form-input width-30',
+          placeholder: 'uuid',
+          value: query.target || '',
+          onChange: function (e) {
+            props.onChange(Object.assign({}, query, { target: e.target.value }));
+          },
+          onBlur: props.onRunQuery,
+        })
+      ),
+      h('div', { className: 'gf-form' },
+        h('span', { className: 'gf-form-label width-10' }, 'Payload'),
+        h('textarea', {
+          className: 'gf-form-input width-30',
+          rows: 3,
+          placeholder: '{"group": "day"}',
+          value: payload[0],
+          onChange: function (e) {
+            payload[1](e.target.value);
+          },
+          onBlur: function () {
+            try {
+              props.onChange(Object.assign({}, query, { payload: JSON.parse(payload[0] || '{}') }));
+              props.onRunQuery();
+            } catch (e) {
+              // keep invalid payload for editing
+            }
+          },
+        })
+      )
+    );
+  }
+
+  var plugin = new data.DataSourcePlugin(DataSource)
+    .setConfigEditor(ConfigEditor)
+    .setQueryEditor(QueryEditor);
+
+  return { plugin: plugin };
+});
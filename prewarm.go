@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andig/gravo/grafana"
+)
+
+// prewarmDataPoints is the number of data points requested if the panel
+// does not define maxDataPoints
+const prewarmDataPoints = 1000
+
+// prewarmQuery is a dashboard query executed periodically to keep caches
+// hot. From and To are Grafana times like now-24h or now/d.
+type prewarmQuery struct {
+	Name          string           `json:"name"`
+	From          string           `json:"from"`
+	To            string           `json:"to"`
+	MaxDataPoints int              `json:"maxDataPoints"`
+	Targets       []grafana.Target `json:"targets"`
+}
+
+// prewarmResult is the outcome of the last execution of a query
+type prewarmResult struct {
+	Name       string    `json:"name"`
+	Time       time.Time `json:"time"`
+	Duration   float64   `json:"duration"` // milliseconds
+	Targets    int       `json:"targets"`
+	Datapoints int       `json:"datapoints"`
+}
+
+// prewarmDashboard is the subset of the Grafana dashboard model needed for
+// extracting queries
+type prewarmDashboard struct {
+	Title  string                `json:"title"`
+	Time   grafana.RelativeRange `json:"time"`
+	Panels []prewarmPanel        `json:"panels"`
+}
+
+type prewarmPanel struct {
+	Title         string            `json:"title"`
+	Datasource    prewarmDatasource `json:"datasource"`
+	TimeFrom      string            `json:"timeFrom"`
+	MaxDataPoints int               `json:"maxDataPoints"`
+	Targets       []json.RawMessage `json:"targets"`
+	Panels        []prewarmPanel    `json:"panels"` // collapsed rows
+}
+
+// builtinDatasources are Grafana's internal datasources
+var builtinDatasources = map[string]bool{
+	"-- grafana --":   true,
+	"-- dashboard --": true,
+	"grafana":         true,
+	"datasource":      true,
+}
+
+// prewarmDatasource is a panel or target datasource given as name or as
+// object with type and uid depending on the Grafana version
+type prewarmDatasource struct {
+	Name string
+	Type string
+	UID  string
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (ds *prewarmDatasource) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &ds.Name); err == nil {
+		return nil
+	}
+
+	var obj struct {
+		Type string `json:"type"`
+		UID  string `json:"uid"`
+	}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+
+	ds.Type, ds.UID = obj.Type, obj.UID
+
+	return nil
+}
+
+// mixed returns true for the mixed datasource which uses the datasources
+// of the targets
+func (ds prewarmDatasource) mixed() bool {
+	return strings.EqualFold(ds.Name, "-- mixed --") || strings.EqualFold(ds.UID, "-- mixed --")
+}
+
+// gravo returns true if the datasource may be gravo. Datasources given by
+// name other than Grafana's internal datasources are assumed to be gravo,
+// missing datasources refer to the default datasource.
+func (ds prewarmDatasource) gravo() bool {
+	switch {
+	case ds.mixed():
+		return false
+	case ds.Type != "":
+		return ds.Type == jsonDatasource || ds.Type == pluginDatasource
+	default:
+		return !builtinDatasources[strings.ToLower(ds.Name)] && !builtinDatasources[strings.ToLower(ds.UID)]
+	}
+}
+
+// loadPrewarmQueries reads a JSON list of queries or a Grafana dashboard
+// from file. Dashboards may be wrapped as returned by the dashboard API.
+func loadPrewarmQueries(path string) ([]prewarmQuery, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var queries []prewarmQuery
+	if err := json.Unmarshal(b, &queries); err == nil {
+		for i, q := range queries {
+			if q.Name == "" {
+				queries[i].Name = fmt.Sprintf("query %d", i+1)
+			}
+			if q.MaxDataPoints == 0 {
+				queries[i].MaxDataPoints = prewarmDataPoints
+			}
+		}
+
+		return queries, nil
+	}
+
+	var wrapped struct {
+		Dashboard *prewarmDashboard `json:"dashboard"`
+	}
+	if err := json.Unmarshal(b, &wrapped); err == nil && wrapped.Dashboard != nil {
+		return dashboardQueries(*wrapped.Dashboard)
+	}
+
+	var dashboard prewarmDashboard
+	if err := json.Unmarshal(b, &dashboard); err != nil {
+		return nil, fmt.Errorf("invalid dashboard: %v", err)
+	}
+
+	return dashboardQueries(dashboard)
+}
+
+// dashboardQueries converts the dashboard panels into queries. Panels
+// with relative time override use it instead of the dashboard time.
+func dashboardQueries(dashboard prewarmDashboard) ([]prewarmQuery, error) {
+	var res []prewarmQuery
+
+	var walk func(panels []prewarmPanel) error
+	walk = func(panels []prewarmPanel) error {
+		for _, panel := range panels {
+			if err := walk(panel.Panels); err != nil {
+				return err
+			}
+
+			if !panel.Datasource.gravo() && !panel.Datasource.mixed() {
+				continue
+			}
+
+			q := prewarmQuery{
+				Name:          strings.TrimSpace(dashboard.Title + "/" + panel.Title),
+				From:          dashboard.Time.From,
+				To:            dashboard.Time.To,
+				MaxDataPoints: panel.MaxDataPoints,
+			}
+
+			if panel.TimeFrom != "" {
+				q.From, q.To = "now-"+panel.TimeFrom, "now"
+			}
+
+			if q.MaxDataPoints == 0 {
+				q.MaxDataPoints = prewarmDataPoints
+			}
+
+			for _, raw := range panel.Targets {
+				target, ok, err := panelTarget(raw, panel.Datasource)
+				if err != nil {
+					return fmt.Errorf("%s: %v", q.Name, err)
+				}
+
+				if ok {
+					q.Targets = append(q.Targets, target)
+				}
+			}
+
+			if len(q.Targets) > 0 {
+				res = append(res, q)
+			}
+		}
+
+		return nil
+	}
+
+	if err := walk(dashboard.Panels); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// panelTarget decodes a panel target. Older dashboards store the payload
+// as JSON string in the data attribute. Hidden targets and targets of other
+// datasources are skipped.
+func panelTarget(raw json.RawMessage, datasource prewarmDatasource) (grafana.Target, bool, error) {
+	var target grafana.Target
+	if err := json.Unmarshal(raw, &target); err != nil {
+		return target, false, err
+	}
+
+	var extra struct {
+		Hide       bool               `json:"hide"`
+		Data       json.RawMessage    `json:"data"`
+		Datasource *prewarmDatasource `json:"datasource"`
+	}
+	if err := json.Unmarshal(raw, &extra); err != nil {
+		return target, false, err
+	}
+
+	// targets of mixed panels define their own datasource
+	if extra.Datasource != nil && (datasource.mixed() || extra.Datasource.Type != "") {
+		datasource = *extra.Datasource
+	}
+
+	if target.Target == "" || extra.Hide || !datasource.gravo() {
+		return target, false, nil
+	}
+
+	var data string
+	if err := json.Unmarshal(extra.Data, &data); err == nil && strings.TrimSpace(data) != "" {
+		if err := json.Unmarshal([]byte(data), &target.Data); err != nil {
+			return target, false, fmt.Errorf("invalid payload: %s", data)
+		}
+	}
+
+	return target, true, nil
+}
+
+var grafanaTimeRE = regexp.MustCompile(`^now(?:([+-]\d+)([smhdwMy]))?(?:/([hdwMy]))?$`)
+
+// grafanaTime parses Grafana time range values like now, now-6h, now-2M,
+// now/w or now-1d/d as well as RFC3339 times and unix milliseconds
+func grafanaTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return now, nil
+	}
+
+	match := grafanaTimeRE.FindStringSubmatch(s)
+	if match == nil {
+		if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return fromUnixMS(ts), nil
+		}
+
+		return time.Parse(time.RFC3339, s)
+	}
+
+	t := now
+	if match[1] != "" {
+		n, _ := strconv.Atoi(match[1])
+
+		switch match[2] {
+		case "s":
+			t = t.Add(time.Duration(n) * time.Second)
+		case "m":
+			t = t.Add(time.Duration(n) * time.Minute)
+		case "h":
+			t = t.Add(time.Duration(n) * time.Hour)
+		case "d":
+			t = t.AddDate(0, 0, n)
+		case "w":
+			t = t.AddDate(0, 0, 7*n)
+		case "M":
+			t = t.AddDate(0, n, 0)
+		case "y":
+			t = t.AddDate(n, 0, 0)
+		}
+	}
+
+	switch match[3] {
+	case "h":
+		t = periodStart(t, "hour")
+	case "d":
+		t = periodStart(t, "day")
+	case "w":
+		t = periodStart(t, "week")
+	case "M":
+		t = periodStart(t, "month")
+	case "y":
+		t = periodStart(t, "year")
+	}
+
+	return t, nil
+}
+
+// prewarmer periodically executes queries and records their durations
+type prewarmer struct {
+	server  *Server
+	queries []prewarmQuery
+
+	mux     sync.Mutex // guards results
+	results []prewarmResult
+}
+
+// warm executes all queries sequentially to limit middleware load
+func (p *prewarmer) warm() {
+	start := time.Now()
+	results := make([]prewarmResult, 0, len(p.queries))
+
+	for _, q := range p.queries {
+		now := time.Now()
+
+		from, err := grafanaTime(q.From, now)
+		if err != nil {
+			log.Printf("prewarm failed: %s: %v", q.Name, err)
+			continue
+		}
+
+		to, err := grafanaTime(q.To, now)
+		if err != nil {
+			log.Printf("prewarm failed: %s: %v", q.Name, err)
+			continue
+		}
+
+		qr := grafana.QueryRequest{
+			Range: grafana.Range{
+				From: from,
+				To:   to,
+				Raw:  grafana.RelativeRange{From: q.From, To: q.To},
+			},
+			RangeRaw:      grafana.RelativeRange{From: q.From, To: q.To},
+			Targets:       q.Targets,
+			MaxDataPoints: q.MaxDataPoints,
+		}
+
+		var datapoints int
+		for _, qres := range p.server.executeQuery(qr) {
+			if qres.Error != nil {
+				log.Printf("prewarm failed: %s: %v", q.Name, qres.Error)
+			}
+			datapoints += len(qres.Datapoints)
+		}
+
+		duration := time.Since(now)
+		log.Printf("prewarm %s: %d targets, %d datapoints (%dms)", q.Name, len(q.Targets), datapoints, duration.Milliseconds())
+
+		results = append(results, prewarmResult{
+			Name:       q.Name,
+			Time:       now,
+			Duration:   float64(duration) / float64(time.Millisecond),
+			Targets:    len(q.Targets),
+			Datapoints: datapoints,
+		})
+	}
+
+	log.Printf("prewarm completed: %d queries (%dms)", len(results), time.Since(start).Milliseconds())
+
+	p.mux.Lock()
+	p.results = results
+	p.mux.Unlock()
+}
+
+// run warms the queries every interval until done is closed
+func (p *prewarmer) run(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	p.warm()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.warm()
+		case <-done:
+			return
+		}
+	}
+}
+
+// enablePrewarm executes the queries every interval in background.
+// Targets may be given as uuid or title path.
+func (server *Server) enablePrewarm(queries []prewarmQuery, interval time.Duration) {
+	if server.rollups == nil {
+		log.Println("prewarm: rollup store disabled, only middleware caches are warmed (enable with -rollups)")
+	}
+
+	for _, q := range queries {
+		for i, target := range q.Targets {
+			q.Targets[i].Target = server.resolveUUID(target.Target)
+		}
+	}
+
+	server.prewarm = &prewarmer{
+		server:  server,
+		queries: queries,
+	}
+
+	go server.prewarm.run(interval, server.done)
+}
+
+// prewarmHandler reports the durations of the last warm-up run
+func (server *Server) prewarmHandler(w http.ResponseWriter, r *http.Request) {
+	if server.prewarm == nil {
+		http.Error(w, "prewarm disabled", http.StatusNotFound)
+		return
+	}
+
+	server.prewarm.mux.Lock()
+	resp := server.prewarm.results
+	server.prewarm.mux.Unlock()
+
+	if resp == nil {
+		resp = []prewarmResult{}
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+
+		return
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andig/gravo/grafana"
+	"github.com/andig/gravo/prometheus"
+	"github.com/andig/gravo/volkszaehler"
+)
+
+var metricNameRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// entityLabels returns the Prometheus labels of an entity. The metric name
+// is derived from the entity type, the group is the parent group path.
+func entityLabels(entity volkszaehler.Entity) map[string]string {
+	labels := map[string]string{
+		"__name__": metricNameRE.ReplaceAllString(strings.ToLower(entity.Type), "_"),
+		"uuid":     entity.UUID,
+		"title":    entity.Title,
+	}
+
+	if idx := strings.LastIndex(entity.Title, "/"); idx >= 0 {
+		labels["group"] = entity.Title[:idx]
+	}
+
+	return labels
+}
+
+// labelMatcher compiles a label matcher into a match function
+func labelMatcher(m prometheus.LabelMatcher) (func(map[string]string) bool, error) {
+	switch m.Type {
+	case prometheus.MatchEqual:
+		return func(labels map[string]string) bool { return labels[m.Name] == m.Value }, nil
+	case prometheus.MatchNotEqual:
+		return func(labels map[string]string) bool { return labels[m.Name] != m.Value }, nil
+	case prometheus.MatchRegexp, prometheus.MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return nil, err
+		}
+
+		negate := m.Type == prometheus.MatchNotRegexp
+		return func(labels map[string]string) bool { return re.MatchString(labels[m.Name]) != negate }, nil
+	default:
+		return nil, fmt.Errorf("invalid matcher type: %d", m.Type)
+	}
+}
+
+func (server *Server) prometheusReadHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req, err := prometheus.DecodeReadRequest(body)
+	if err != nil {
+		log.Printf("protobuf decode failed: %v", err)
+		http.Error(w, fmt.Sprintf("protobuf decode failed: %v", err), http.StatusBadRequest)
+
+		return
+	}
+
+	resp := prometheus.ReadResponse{}
+	for _, q := range req.Queries {
+		res, err := server.executePrometheusQuery(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp.Results = append(resp.Results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+
+	if _, err := w.Write(prometheus.EncodeReadResponse(resp)); err != nil {
+		log.Printf("write failed: %v", err)
+	}
+}
+
+// executePrometheusQuery selects the matching entities and queries their
+// data. The step hint determines the number of tuples. Like Prometheus,
+// queries must contain a matcher not matching empty labels to avoid
+// querying all entities.
+func (server *Server) executePrometheusQuery(q prometheus.Query) (prometheus.QueryResult, error) {
+	res := prometheus.QueryResult{}
+
+	var selective bool
+	matchers := make([]func(map[string]string) bool, 0, len(q.Matchers))
+	for _, m := range q.Matchers {
+		matcher, err := labelMatcher(m)
+		if err != nil {
+			return res, err
+		}
+		matchers = append(matchers, matcher)
+
+		if !matcher(map[string]string{}) {
+			selective = true
+		}
+	}
+
+	if !selective {
+		return res, errors.New("query must contain at least one matcher not matching empty labels")
+	}
+
+	const n2m = int64(time.Millisecond) // nano to milli seconds
+	qr := grafana.QueryRequest{
+		Range: grafana.Range{
+			From: time.Unix(0, q.StartTimestampMs*n2m),
+			To:   time.Unix(0, q.EndTimestampMs*n2m),
+		},
+	}
+
+	if q.Hints.StepMs > 0 {
+		qr.MaxDataPoints = int(math.Ceil(float64(q.EndTimestampMs-q.StartTimestampMs) / float64(q.Hints.StepMs)))
+	}
+
+	var entities []volkszaehler.Entity
+
+ENTITIES:
+	for _, entity := range server.getPublicEntites() {
+		labels := entityLabels(entity)
+
+		for _, matcher := range matchers {
+			if !matcher(labels) {
+				continue ENTITIES
+			}
+		}
+
+		entities = append(entities, entity)
+	}
+
+	res.Timeseries = make([]prometheus.TimeSeries, len(entities))
+	wg := &sync.WaitGroup{}
+
+	for idx, entity := range entities {
+		wg.Add(1)
+
+		go func(idx int, entity volkszaehler.Entity) {
+			res.Timeseries[idx] = server.prometheusSeries(entity, &qr)
+			wg.Done()
+		}(idx, entity)
+	}
+
+	wg.Wait()
+
+	return res, nil
+}
+
+// prometheusSeries queries the entity's data as labeled time series
+func (server *Server) prometheusSeries(entity volkszaehler.Entity, qr *grafana.QueryRequest) prometheus.TimeSeries {
+	labels := entityLabels(entity)
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ts := prometheus.TimeSeries{}
+	for _, name := range names {
+		ts.Labels = append(ts.Labels, prometheus.Label{Name: name, Value: labels[name]})
+	}
+
+	qres := server.queryData(grafana.Target{Target: entity.UUID}, qr)
+
+	ts.Samples = make([]prometheus.Sample, 0, len(qres.Datapoints))
+	for _, dp := range qres.Datapoints {
+		if !dp.Null {
+			ts.Samples = append(ts.Samples, prometheus.Sample{Value: dp.Value, Timestamp: dp.Timestamp})
+		}
+	}
+
+	return ts
+}
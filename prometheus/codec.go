@@ -0,0 +1,211 @@
+package prometheus
+
+import (
+	"errors"
+	"math"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var errInvalidMessage = errors.New("invalid protobuf message")
+
+// DecodeReadRequest decodes a snappy-compressed protobuf read request
+func DecodeReadRequest(compressed []byte) (ReadRequest, error) {
+	var req ReadRequest
+
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return req, err
+	}
+
+	err = consumeMessage(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num == 1 && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, errInvalidMessage
+			}
+
+			q, err := decodeQuery(v)
+			req.Queries = append(req.Queries, q)
+
+			return n, err
+		}
+
+		return skip(num, typ, b)
+	})
+
+	return req, err
+}
+
+func decodeQuery(b []byte) (Query, error) {
+	var q Query
+
+	err := consumeMessage(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			q.StartTimestampMs = int64(v)
+			return n, nil
+
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			q.EndTimestampMs = int64(v)
+			return n, nil
+
+		case num == 3 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, errInvalidMessage
+			}
+
+			m, err := decodeMatcher(v)
+			q.Matchers = append(q.Matchers, m)
+
+			return n, err
+
+		case num == 4 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, errInvalidMessage
+			}
+
+			h, err := decodeHints(v)
+			q.Hints = h
+
+			return n, err
+		}
+
+		return skip(num, typ, b)
+	})
+
+	return q, err
+}
+
+func decodeMatcher(b []byte) (LabelMatcher, error) {
+	var m LabelMatcher
+
+	err := consumeMessage(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			m.Type = MatchType(v)
+			return n, nil
+
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			m.Name = v
+			return n, nil
+
+		case num == 3 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			m.Value = v
+			return n, nil
+		}
+
+		return skip(num, typ, b)
+	})
+
+	return m, err
+}
+
+func decodeHints(b []byte) (ReadHints, error) {
+	var h ReadHints
+
+	err := consumeMessage(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			h.StepMs = int64(v)
+			return n, nil
+
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			h.Func = v
+			return n, nil
+		}
+
+		return skip(num, typ, b)
+	})
+
+	return h, err
+}
+
+// consumeMessage iterates the fields of a message. The field function
+// returns the number of bytes consumed from the field value.
+func consumeMessage(b []byte, field func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errInvalidMessage
+		}
+		b = b[n:]
+
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errInvalidMessage
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
+
+func skip(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	n := protowire.ConsumeFieldValue(num, typ, b)
+	if n < 0 {
+		return n, errInvalidMessage
+	}
+
+	return n, nil
+}
+
+// EncodeReadResponse encodes a read response as snappy-compressed protobuf
+func EncodeReadResponse(resp ReadResponse) []byte {
+	var b []byte
+
+	for _, result := range resp.Results {
+		var rb []byte
+		for _, ts := range result.Timeseries {
+			rb = appendMessage(rb, 1, encodeTimeSeries(ts))
+		}
+
+		b = appendMessage(b, 1, rb)
+	}
+
+	return snappy.Encode(nil, b)
+}
+
+func encodeTimeSeries(ts TimeSeries) []byte {
+	var b []byte
+
+	for _, label := range ts.Labels {
+		var lb []byte
+		lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+		lb = protowire.AppendString(lb, label.Name)
+		lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+		lb = protowire.AppendString(lb, label.Value)
+
+		b = appendMessage(b, 1, lb)
+	}
+
+	for _, sample := range ts.Samples {
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(sample.Value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(sample.Timestamp))
+
+		b = appendMessage(b, 2, sb)
+	}
+
+	return b
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
@@ -0,0 +1,284 @@
+package prometheus
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// remoteProto describes the messages of prompb/remote.proto and
+// prompb/types.proto used by remote read. Enums are declared as int32
+// which has the same wire format.
+func remoteProto(t *testing.T) protoreflect.FileDescriptor {
+	const (
+		optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	)
+
+	field := func(name string, num int32, label descriptorpb.FieldDescriptorProto_Label, typ descriptorpb.FieldDescriptorProto_Type, msg string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(num),
+			Label:  label.Enum(),
+			Type:   typ.Enum(),
+		}
+		if msg != "" {
+			f.TypeName = proto.String(".prometheus." + msg)
+		}
+		return f
+	}
+
+	message := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+
+	const (
+		int32Type   = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		int64Type   = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		doubleType  = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+		boolType    = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+		stringType  = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		messageType = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("remote.proto"),
+		Package: proto.String("prometheus"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("ReadRequest",
+				field("queries", 1, repeated, messageType, "Query"),
+				field("accepted_response_types", 2, repeated, int32Type, ""),
+			),
+			message("Query",
+				field("start_timestamp_ms", 1, optional, int64Type, ""),
+				field("end_timestamp_ms", 2, optional, int64Type, ""),
+				field("matchers", 3, repeated, messageType, "LabelMatcher"),
+				field("hints", 4, optional, messageType, "ReadHints"),
+			),
+			message("LabelMatcher",
+				field("type", 1, optional, int32Type, ""),
+				field("name", 2, optional, stringType, ""),
+				field("value", 3, optional, stringType, ""),
+			),
+			message("ReadHints",
+				field("step_ms", 1, optional, int64Type, ""),
+				field("func", 2, optional, stringType, ""),
+				field("start_ms", 3, optional, int64Type, ""),
+				field("end_ms", 4, optional, int64Type, ""),
+				field("grouping", 5, repeated, stringType, ""),
+				field("by", 6, optional, boolType, ""),
+				field("range_ms", 7, optional, int64Type, ""),
+			),
+			message("ReadResponse",
+				field("results", 1, repeated, messageType, "QueryResult"),
+			),
+			message("QueryResult",
+				field("timeseries", 1, repeated, messageType, "TimeSeries"),
+			),
+			message("TimeSeries",
+				field("labels", 1, repeated, messageType, "Label"),
+				field("samples", 2, repeated, messageType, "Sample"),
+			),
+			message("Label",
+				field("name", 1, optional, stringType, ""),
+				field("value", 2, optional, stringType, ""),
+			),
+			message("Sample",
+				field("value", 1, optional, doubleType, ""),
+				field("timestamp", 2, optional, int64Type, ""),
+			),
+		},
+	}
+
+	file, err := protodesc.NewFile(fd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+// newMessage creates a dynamic message and sets its fields
+func newMessage(file protoreflect.FileDescriptor, name string, fields map[string]interface{}) *dynamicpb.Message {
+	md := file.Messages().ByName(protoreflect.Name(name))
+	m := dynamicpb.NewMessage(md)
+
+	for name, value := range fields {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+
+		switch v := value.(type) {
+		case []*dynamicpb.Message:
+			list := m.Mutable(fd).List()
+			for _, msg := range v {
+				list.Append(protoreflect.ValueOfMessage(msg))
+			}
+		case []int32:
+			list := m.Mutable(fd).List()
+			for _, i := range v {
+				list.Append(protoreflect.ValueOfInt32(i))
+			}
+		case []string:
+			list := m.Mutable(fd).List()
+			for _, s := range v {
+				list.Append(protoreflect.ValueOfString(s))
+			}
+		case *dynamicpb.Message:
+			m.Set(fd, protoreflect.ValueOfMessage(v))
+		default:
+			m.Set(fd, protoreflect.ValueOf(v))
+		}
+	}
+
+	return m
+}
+
+func TestDecodeReadRequest(t *testing.T) {
+	file := remoteProto(t)
+
+	// request as sent by Prometheus for power{group="House"}[5m] and
+	// {__name__=~"temp.*",title!="Outside"}
+	msg := newMessage(file, "ReadRequest", map[string]interface{}{
+		"queries": []*dynamicpb.Message{
+			newMessage(file, "Query", map[string]interface{}{
+				"start_timestamp_ms": int64(1600000000000),
+				"end_timestamp_ms":   int64(1600000300000),
+				"matchers": []*dynamicpb.Message{
+					newMessage(file, "LabelMatcher", map[string]interface{}{"type": int32(0), "name": "__name__", "value": "power"}),
+					newMessage(file, "LabelMatcher", map[string]interface{}{"type": int32(0), "name": "group", "value": "House"}),
+				},
+				"hints": newMessage(file, "ReadHints", map[string]interface{}{
+					"step_ms":  int64(15000),
+					"func":     "rate",
+					"start_ms": int64(1600000000000),
+					"end_ms":   int64(1600000300000),
+					"grouping": []string{"title"},
+					"by":       true,
+					"range_ms": int64(300000),
+				}),
+			}),
+			newMessage(file, "Query", map[string]interface{}{
+				"start_timestamp_ms": int64(1600000000000),
+				"end_timestamp_ms":   int64(1600003600000),
+				"matchers": []*dynamicpb.Message{
+					newMessage(file, "LabelMatcher", map[string]interface{}{"type": int32(2), "name": "__name__", "value": "temp.*"}),
+					newMessage(file, "LabelMatcher", map[string]interface{}{"type": int32(1), "name": "title", "value": "Outside"}),
+				},
+			}),
+		},
+		"accepted_response_types": []int32{0},
+	})
+
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := DecodeReadRequest(snappy.Encode(nil, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ReadRequest{
+		Queries: []Query{
+			{
+				StartTimestampMs: 1600000000000,
+				EndTimestampMs:   1600000300000,
+				Matchers: []LabelMatcher{
+					{Type: MatchEqual, Name: "__name__", Value: "power"},
+					{Type: MatchEqual, Name: "group", Value: "House"},
+				},
+				Hints: ReadHints{StepMs: 15000, Func: "rate"},
+			},
+			{
+				StartTimestampMs: 1600000000000,
+				EndTimestampMs:   1600003600000,
+				Matchers: []LabelMatcher{
+					{Type: MatchRegexp, Name: "__name__", Value: "temp.*"},
+					{Type: MatchNotEqual, Name: "title", Value: "Outside"},
+				},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(req, expected) {
+		t.Errorf("expected %+v, got %+v", expected, req)
+	}
+}
+
+func TestDecodeReadRequestInvalid(t *testing.T) {
+	for _, b := range [][]byte{
+		[]byte("not snappy"),
+		snappy.Encode(nil, []byte{0x0a, 0x05, 0x08}), // truncated query
+		snappy.Encode(nil, []byte{0xff}),             // invalid tag
+	} {
+		if _, err := DecodeReadRequest(b); err == nil {
+			t.Errorf("%x: expected error", b)
+		}
+	}
+}
+
+func TestEncodeReadResponse(t *testing.T) {
+	file := remoteProto(t)
+
+	resp := ReadResponse{
+		Results: []QueryResult{
+			{Timeseries: []TimeSeries{
+				{
+					Labels: []Label{{Name: "__name__", Value: "power"}, {Name: "title", Value: "House/Grid"}},
+					Samples: []Sample{
+						{Value: 123456.789, Timestamp: 1600000000000},
+						{Value: -1.5, Timestamp: 1600000060000},
+					},
+				},
+				{Labels: []Label{{Name: "__name__", Value: "power"}}},
+			}},
+			{},
+		},
+	}
+
+	expected := newMessage(file, "ReadResponse", map[string]interface{}{
+		"results": []*dynamicpb.Message{
+			newMessage(file, "QueryResult", map[string]interface{}{
+				"timeseries": []*dynamicpb.Message{
+					newMessage(file, "TimeSeries", map[string]interface{}{
+						"labels": []*dynamicpb.Message{
+							newMessage(file, "Label", map[string]interface{}{"name": "__name__", "value": "power"}),
+							newMessage(file, "Label", map[string]interface{}{"name": "title", "value": "House/Grid"}),
+						},
+						"samples": []*dynamicpb.Message{
+							newMessage(file, "Sample", map[string]interface{}{"value": 123456.789, "timestamp": int64(1600000000000)}),
+							newMessage(file, "Sample", map[string]interface{}{"value": -1.5, "timestamp": int64(1600000060000)}),
+						},
+					}),
+					newMessage(file, "TimeSeries", map[string]interface{}{
+						"labels": []*dynamicpb.Message{
+							newMessage(file, "Label", map[string]interface{}{"name": "__name__", "value": "power"}),
+						},
+					}),
+				},
+			}),
+			newMessage(file, "QueryResult", nil),
+		},
+	})
+
+	b, err := snappy.Decode(nil, EncodeReadResponse(resp))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := dynamicpb.NewMessage(file.Messages().ByName("ReadResponse"))
+	if err := proto.Unmarshal(b, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if !proto.Equal(msg, expected) {
+		t.Errorf("expected %v, got %v", expected, msg)
+	}
+}
@@ -0,0 +1,69 @@
+package prometheus
+
+// Messages of the Prometheus remote read protocol
+// https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto
+
+// MatchType is the label matcher type
+type MatchType int
+
+// Label matcher types
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+// ReadRequest is the remote read request
+type ReadRequest struct {
+	Queries []Query
+}
+
+// Query selects series by label matchers within a time range
+type Query struct {
+	StartTimestampMs int64
+	EndTimestampMs   int64
+	Matchers         []LabelMatcher
+	Hints            ReadHints
+}
+
+// LabelMatcher matches a label value
+type LabelMatcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+}
+
+// ReadHints contains optional query details
+type ReadHints struct {
+	StepMs int64
+	Func   string
+}
+
+// ReadResponse is the remote read response
+type ReadResponse struct {
+	Results []QueryResult
+}
+
+// QueryResult contains the series of a single query
+type QueryResult struct {
+	Timeseries []TimeSeries
+}
+
+// TimeSeries is a labeled series of samples
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Label is a name/value pair
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single data point
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
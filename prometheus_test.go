@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/andig/gravo/prometheus"
+	"github.com/andig/gravo/volkszaehler"
+)
+
+func TestPrometheusQueryMatchers(t *testing.T) {
+	server := newServer(&fakeAPI{
+		entities: []volkszaehler.Entity{
+			{UUID: "c1", Type: "power", Title: "House/Grid"},
+			{UUID: "c2", Type: "temperature", Title: "House/Living Room"},
+			{UUID: "c3", Type: "power", Title: "Garage"},
+		},
+	}, 0, nil)
+	defer server.Close()
+
+	tc := []struct {
+		matchers []prometheus.LabelMatcher
+		series   int
+		err      bool
+	}{
+		{nil, 0, true},
+		{[]prometheus.LabelMatcher{{Type: prometheus.MatchRegexp, Name: "title", Value: ".*"}}, 0, true},
+		{[]prometheus.LabelMatcher{{Type: prometheus.MatchNotEqual, Name: "title", Value: "Garage"}}, 0, true},
+		{[]prometheus.LabelMatcher{{Type: prometheus.MatchEqual, Name: "__name__", Value: "power"}}, 2, false},
+		{[]prometheus.LabelMatcher{{Type: prometheus.MatchRegexp, Name: "__name__", Value: ".+"}}, 3, false},
+		{[]prometheus.LabelMatcher{
+			{Type: prometheus.MatchEqual, Name: "__name__", Value: "power"},
+			{Type: prometheus.MatchEqual, Name: "group", Value: "House"},
+		}, 1, false},
+		{[]prometheus.LabelMatcher{{Type: prometheus.MatchRegexp, Name: "title", Value: "("}}, 0, true},
+	}
+
+	for _, c := range tc {
+		res, err := server.executePrometheusQuery(prometheus.Query{Matchers: c.matchers})
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%+v: expected error", c.matchers)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", c.matchers, err)
+			continue
+		}
+
+		if len(res.Timeseries) != c.series {
+			t.Errorf("%+v: expected %d series, got %d", c.matchers, c.series, len(res.Timeseries))
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// datasource plugin types
+const (
+	jsonDatasource   = "simpod-json-datasource"
+	pluginDatasource = "andig-gravo-datasource"
+)
+
+// provisioningTmpl is the Grafana datasource provisioning file
+// https://grafana.com/docs/grafana/latest/administration/provisioning/#data-sources
+var provisioningTmpl = template.Must(template.New("provisioning").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+}).Parse(`# Grafana datasource provisioning generated by gravo {{.Version}}
+apiVersion: 1
+
+datasources:
+  - name: {{quote .Name}}
+    type: {{.Type}}
+    access: proxy
+    url: {{quote .URL}}
+    isDefault: {{.Default}}
+    editable: true
+{{- if .Private}}
+    jsonData:
+      private: {{quote .Private}}
+{{- end}}
+{{- if .Payloads}}
+
+# Query payload fields, e.g. {"group": "day", "options": "consumption"}:
+{{- range .Payloads}}
+#   {{.}}
+{{- end}}
+{{- end}}
+`))
+
+// provisioning holds the datasource provisioning parameters
+type provisioning struct {
+	Version  string
+	Name     string
+	Type     string
+	URL      string
+	Default  bool
+	Private  string
+	Payloads []string
+}
+
+// serverURL returns the url Grafana uses for accessing gravo given the
+// listen address. Unspecified listen hosts are replaced by host.
+func serverURL(listen, host string) (string, error) {
+	listenHost, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", err
+	}
+
+	if host == "" {
+		host = listenHost
+	}
+
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+
+	return "http://" + net.JoinHostPort(host, port), nil
+}
+
+// newProvisioning creates the provisioning parameters for the datasource
+// type. The JSON datasource connects to the gravo server, the backend
+// plugin directly to the middleware.
+func newProvisioning(name, typ, host string, isDefault bool) (provisioning, error) {
+	p := provisioning{
+		Version: version,
+		Name:    name,
+		Default: isDefault,
+	}
+
+	switch strings.ToLower(typ) {
+	case "json", jsonDatasource:
+		u, err := serverURL(*url, host)
+		if err != nil {
+			return p, err
+		}
+
+		p.Type = jsonDatasource
+		p.URL = u
+
+		for _, payload := range metricPayloads() {
+			values := make([]string, 0, len(payload.Options))
+			for _, option := range payload.Options {
+				values = append(values, option.Value)
+			}
+
+			desc := payload.Placeholder
+			if len(values) > 0 {
+				desc = strings.Join(values, ", ")
+			}
+
+			p.Payloads = append(p.Payloads, fmt.Sprintf("%s: %s", payload.Name, desc))
+		}
+
+	case "plugin", pluginDatasource:
+		p.Type = pluginDatasource
+		p.URL = *apiURL
+		p.Private = strings.Join(privateUUIDs(*private), ",")
+
+	default:
+		return p, fmt.Errorf("unsupported datasource type: %s", typ)
+	}
+
+	return p, nil
+}
+
+// writeProvisioning writes the datasource provisioning yaml
+func writeProvisioning(w io.Writer, p provisioning) error {
+	return provisioningTmpl.Execute(w, p)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// enablePush receives channel updates from the volkszaehler push server
+// in background. The latest values are used for MQTT publishing and
+// streaming, not for regular queries.
+func (server *Server) enablePush(url string) {
+	server.latest = volkszaehler.NewLatestValues()
+	go volkszaehler.NewPushClient(url, server.latest).Run(server.done)
+}
+
+// latestTuple returns the latest non-null tuple of the channel within the
+// lookback period. Values received from the push server are used if
+// recent enough, otherwise the middleware is queried.
+func (server *Server) latestTuple(uuid string, lookback time.Duration) (volkszaehler.Tuple, bool, error) {
+	to := time.Now()
+	from := to.Add(-lookback)
+
+	if server.latest != nil {
+		if tuple, ok := server.latest.Latest(uuid); ok && tuple.Timestamp >= from.UnixNano()/int64(time.Millisecond) {
+			return tuple, true, nil
+		}
+	}
+
+	data, err := server.api.QueryData(uuid, from, to, "", "", 0)
+	if err != nil {
+		return volkszaehler.Tuple{}, false, err
+	}
+
+	for i := len(data) - 1; i >= 0; i-- {
+		if !data[i].Null {
+			return data[i], true, nil
+		}
+	}
+
+	return volkszaehler.Tuple{}, false, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// registryFailureTTL is the time failed lookups are cached
+const registryFailureTTL = 5 * time.Minute
+
+// errEntityUnknown is returned while a failed lookup is cached
+var errEntityUnknown = errors.New("unknown entity")
+
+// entityRegistry holds private entities resolved by uuid. Entities are
+// looked up via the middleware on first use and cached afterwards. Failed
+// lookups, e.g. for titles or unresolved variables, are cached for
+// registryFailureTTL.
+type entityRegistry struct {
+	api      volkszaehler.Client
+	mux      sync.RWMutex // guards entities and failed
+	entities map[string]volkszaehler.Entity
+	failed   map[string]time.Time
+}
+
+func newEntityRegistry(api volkszaehler.Client) *entityRegistry {
+	return &entityRegistry{
+		api:      api,
+		entities: make(map[string]volkszaehler.Entity),
+		failed:   make(map[string]time.Time),
+	}
+}
+
+// entity returns the entity for uuid, querying the middleware if it is
+// not yet known
+func (registry *entityRegistry) entity(uuid string) (volkszaehler.Entity, error) {
+	registry.mux.RLock()
+	entity, ok := registry.entities[uuid]
+	failed, isFailed := registry.failed[uuid]
+	registry.mux.RUnlock()
+
+	if ok {
+		return entity, nil
+	}
+
+	if isFailed && time.Since(failed) < registryFailureTTL {
+		return entity, errEntityUnknown
+	}
+
+	entity, err := registry.api.QueryEntity(uuid)
+
+	registry.mux.Lock()
+	if err != nil {
+		registry.failed[uuid] = time.Now()
+	} else {
+		delete(registry.failed, uuid)
+		registry.entities[uuid] = entity
+	}
+	registry.mux.Unlock()
+
+	if err != nil {
+		return entity, err
+	}
+
+	return entity, nil
+}
+
+// entity returns a public or private entity
+func (server *Server) entity(uuid string) (volkszaehler.Entity, bool) {
+	if entity, ok := server.cache.entity(uuid); ok {
+		return entity, true
+	}
+
+	entity, err := server.registry.entity(uuid)
+	if err != nil {
+		if err != errEntityUnknown {
+			log.Printf("entity lookup failed: %v", err)
+		}
+		return entity, false
+	}
+
+	return entity, true
+}
+
+// resolveUUID returns the uuid of the entity identified by uuid or title
+// path. Private entities can only be identified by uuid.
+func (server *Server) resolveUUID(target string) string {
+	if _, ok := server.cache.entity(target); ok {
+		return target
+	}
+
+	for _, entity := range server.getPublicEntites() {
+		if entity.Title == target {
+			return entity.UUID
+		}
+	}
+
+	return target
+}
+
+// getPrivateEntities returns the configured private entities
+func (server *Server) getPrivateEntities() []volkszaehler.Entity {
+	entities := make([]volkszaehler.Entity, 0, len(server.private))
+
+	for _, uuid := range server.private {
+		entity, err := server.registry.entity(uuid)
+		if err != nil {
+			log.Printf("entity lookup failed: %v", err)
+			continue
+		}
+
+		entities = append(entities, entity)
+	}
+
+	return entities
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/andig/gravo/volkszaehler"
+	bolt "go.etcd.io/bbolt"
+)
+
+// rollupGroups are the groups maintained by the rollup store
+var rollupGroups = map[string]bool{
+	"hour":  true,
+	"day":   true,
+	"month": true,
+}
+
+func unixMS(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromUnixMS(ts int64) time.Time {
+	return time.Unix(0, ts*int64(time.Millisecond))
+}
+
+// rollupStore keeps grouped channel data of completed periods in a bolt
+// database. Each channel and group has a single contiguous range of
+// periods known to be complete, which is extended as queries arrive.
+//
+// Layout: bucket <group>/<uuid> holds tuples keyed by period start,
+// bucket coverage holds the complete range keyed by <group>/<uuid>.
+type rollupStore struct {
+	db    *bolt.DB
+	grace time.Duration // delay before periods are considered complete
+}
+
+var coverageBucket = []byte("coverage")
+
+// openRollupStore opens or creates the store file. Periods are stored once
+// they have ended for at least grace to allow for late data.
+func openRollupStore(path string, grace time.Duration) (*rollupStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return &rollupStore{db: db, grace: grace}, nil
+}
+
+// Close closes the store file
+func (store *rollupStore) Close() error {
+	return store.db.Close()
+}
+
+func rollupKey(uuid, group string) []byte {
+	return []byte(group + "/" + uuid)
+}
+
+func encodeTimestamp(ts int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(ts))
+	return b
+}
+
+func encodeTuple(tuple volkszaehler.Tuple) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, math.Float64bits(tuple.Value))
+	binary.BigEndian.PutUint64(b[8:], uint64(tuple.Count))
+	return b
+}
+
+func decodeTuple(k, v []byte) volkszaehler.Tuple {
+	return volkszaehler.Tuple{
+		Timestamp: int64(binary.BigEndian.Uint64(k)),
+		Value:     math.Float64frombits(binary.BigEndian.Uint64(v)),
+		Count:     int(binary.BigEndian.Uint64(v[8:])),
+	}
+}
+
+// coverage returns the range [from, to) of complete periods. The range is
+// empty if nothing is stored.
+func (store *rollupStore) coverage(uuid, group string) (from, to int64, err error) {
+	err = store.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(coverageBucket); b != nil {
+			if v := b.Get(rollupKey(uuid, group)); len(v) == 16 {
+				from = int64(binary.BigEndian.Uint64(v))
+				to = int64(binary.BigEndian.Uint64(v[8:]))
+			}
+		}
+		return nil
+	})
+
+	return from, to, err
+}
+
+// read returns the stored tuples with period start in [from, to)
+func (store *rollupStore) read(uuid, group string, from, to int64) ([]volkszaehler.Tuple, error) {
+	var res []volkszaehler.Tuple
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(rollupKey(uuid, group))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Seek(encodeTimestamp(from)); k != nil && int64(binary.BigEndian.Uint64(k)) < to; k, v = c.Next() {
+			res = append(res, decodeTuple(k, v))
+		}
+
+		return nil
+	})
+
+	return res, err
+}
+
+// write stores the tuples of the complete periods [from, to) and extends
+// the coverage. Non-adjacent ranges replace the coverage.
+func (store *rollupStore) write(uuid, group string, from, to int64, tuples []volkszaehler.Tuple) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(rollupKey(uuid, group))
+		if err != nil {
+			return err
+		}
+
+		for _, tuple := range tuples {
+			if err := b.Put(encodeTimestamp(tuple.Timestamp), encodeTuple(tuple)); err != nil {
+				return err
+			}
+		}
+
+		cb, err := tx.CreateBucketIfNotExists(coverageBucket)
+		if err != nil {
+			return err
+		}
+
+		key := rollupKey(uuid, group)
+		if v := cb.Get(key); len(v) == 16 {
+			covFrom := int64(binary.BigEndian.Uint64(v))
+			covTo := int64(binary.BigEndian.Uint64(v[8:]))
+
+			if from <= covTo && to >= covFrom {
+				if covFrom < from {
+					from = covFrom
+				}
+				if covTo > to {
+					to = covTo
+				}
+			}
+		}
+
+		v := append(encodeTimestamp(from), encodeTimestamp(to)...)
+		return cb.Put(key, v)
+	})
+}
+
+// invalidate removes the periods of all groups starting with the period
+// containing ts from the store
+func (store *rollupStore) invalidate(uuid string, ts int64) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		cb := tx.Bucket(coverageBucket)
+		if cb == nil {
+			return nil
+		}
+
+		for group := range rollupGroups {
+			key := rollupKey(uuid, group)
+
+			v := cb.Get(key)
+			if len(v) != 16 {
+				continue
+			}
+
+			covFrom := int64(binary.BigEndian.Uint64(v))
+			covTo := int64(binary.BigEndian.Uint64(v[8:]))
+
+			start := unixMS(periodStart(fromUnixMS(ts), group))
+			if start >= covTo {
+				continue
+			}
+
+			if b := tx.Bucket(key); b != nil {
+				c := b.Cursor()
+				for k, _ := c.Seek(encodeTimestamp(start)); k != nil; k, _ = c.Next() {
+					if err := c.Delete(); err != nil {
+						return err
+					}
+				}
+			}
+
+			var err error
+			if start <= covFrom {
+				err = cb.Delete(key)
+			} else {
+				err = cb.Put(key, append(encodeTimestamp(covFrom), encodeTimestamp(start)...))
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// fetch queries [from, to) from the middleware and stores the periods
+// completed before cutoff. Tuple timestamps are rounded to period start.
+func (store *rollupStore) fetch(api volkszaehler.Client, uuid, group string, from, to, cutoff time.Time) ([]volkszaehler.Tuple, error) {
+	data, err := api.QueryData(uuid, from, to, group, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var res, complete []volkszaehler.Tuple
+	for _, tuple := range data {
+		start := periodStart(fromUnixMS(tuple.Timestamp), group)
+		if tuple.Null || start.Before(from) || !start.Before(to) {
+			continue
+		}
+
+		tuple.Timestamp = unixMS(start)
+		res = append(res, tuple)
+
+		if start.Before(cutoff) {
+			complete = append(complete, tuple)
+		}
+	}
+
+	end := to
+	if cutoff.Before(end) {
+		end = cutoff
+	}
+
+	if from.Before(end) {
+		if err := store.write(uuid, group, unixMS(from), unixMS(end), complete); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// queryData returns grouped data for [from, to). Complete periods are
+// served from the store, missing periods and periods ended less than grace
+// ago are queried from the middleware. Like the middleware, the result is
+// packed into the given number of tuples if positive.
+func (store *rollupStore) queryData(api volkszaehler.Client, uuid string, from, to time.Time, group string, tuples int) ([]volkszaehler.Tuple, error) {
+	from = periodStart(from, group)
+	cutoff := periodStart(time.Now().Add(-store.grace), group)
+
+	covFrom, covTo, err := store.coverage(uuid, group)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[int64]volkszaehler.Tuple)
+	add := func(tuples []volkszaehler.Tuple) {
+		for _, tuple := range tuples {
+			res[tuple.Timestamp] = tuple
+		}
+	}
+
+	start, end := fromUnixMS(covFrom), fromUnixMS(covTo)
+
+	if covFrom == covTo || !from.Before(end) || !to.After(start) {
+		// nothing stored for the range
+		tuples, err := store.fetch(api, uuid, group, from, to, cutoff)
+		if err != nil {
+			return nil, err
+		}
+		add(tuples)
+	} else {
+		if from.Before(start) {
+			tuples, err := store.fetch(api, uuid, group, from, start, cutoff)
+			if err != nil {
+				return nil, err
+			}
+			add(tuples)
+		}
+
+		if to.After(end) {
+			tuples, err := store.fetch(api, uuid, group, end, to, cutoff)
+			if err != nil {
+				return nil, err
+			}
+			add(tuples)
+		}
+
+		if from.After(start) {
+			start = from
+		}
+		if to.Before(end) {
+			end = to
+		}
+
+		tuples, err := store.read(uuid, group, unixMS(start), unixMS(end))
+		if err != nil {
+			return nil, err
+		}
+		add(tuples)
+	}
+
+	data := make([]volkszaehler.Tuple, 0, len(res))
+	for _, tuple := range res {
+		data = append(data, tuple)
+	}
+
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].Timestamp < data[j].Timestamp
+	})
+
+	return downsample(data, "avg", tuples)
+}
+
+// enableRollups serves grouped queries from the rollup store at path
+func (server *Server) enableRollups(path string, grace time.Duration) error {
+	store, err := openRollupStore(path, grace)
+	if err != nil {
+		return err
+	}
+
+	server.rollups = store
+
+	return nil
+}
+
+// invalidateRollups removes the stored periods affected by tuples written
+// to the channel
+func (server *Server) invalidateRollups(uuid string, tuples []volkszaehler.Tuple) {
+	if server.rollups == nil || len(tuples) == 0 {
+		return
+	}
+
+	first := tuples[0].Timestamp
+	for _, tuple := range tuples {
+		if tuple.Timestamp < first {
+			first = tuple.Timestamp
+		}
+	}
+
+	if err := server.rollups.invalidate(uuid, first); err != nil {
+		log.Printf("rollup invalidation failed: %s: %v", uuid, err)
+	}
+}
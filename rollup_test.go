@@ -0,0 +1,209 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// hourlyAPI returns a tuple per hour in the middle of each period and
+// records the queried ranges
+type hourlyAPI struct {
+	fakeAPI
+	queries [][2]time.Time
+}
+
+func (api *hourlyAPI) QueryData(uuid string, from time.Time, to time.Time, group string, options string, tuples int) ([]volkszaehler.Tuple, error) {
+	api.queries = append(api.queries, [2]time.Time{from, to})
+
+	var res []volkszaehler.Tuple
+	for t := from; t.Before(to); t = t.Add(time.Hour) {
+		res = append(res, volkszaehler.Tuple{Timestamp: unixMS(t.Add(30 * time.Minute)), Value: float64(t.Hour()), Count: 1})
+	}
+
+	return res, nil
+}
+
+func newTestRollupStore(t *testing.T, grace time.Duration) (*rollupStore, func()) {
+	dir, err := ioutil.TempDir("", "rollup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := openRollupStore(filepath.Join(dir, "rollups.db"), grace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store, func() {
+		_ = store.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestRollupStoreWrite(t *testing.T) {
+	store, cleanup := newTestRollupStore(t, 0)
+	defer cleanup()
+
+	tc := []struct {
+		from, to       int64
+		covFrom, covTo int64
+	}{
+		{100, 200, 100, 200}, // initial
+		{200, 300, 100, 300}, // adjacent after
+		{50, 100, 50, 300},   // adjacent before
+		{80, 350, 50, 350},   // overlapping
+		{100, 200, 50, 350},  // contained
+		{500, 600, 500, 600}, // non-adjacent replaces
+		{0, 10, 0, 10},       // non-adjacent before replaces
+	}
+
+	for _, c := range tc {
+		if err := store.write("uuid", "hour", c.from, c.to, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		from, to, err := store.coverage("uuid", "hour")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if from != c.covFrom || to != c.covTo {
+			t.Errorf("write [%d, %d): expected coverage [%d, %d), got [%d, %d)", c.from, c.to, c.covFrom, c.covTo, from, to)
+		}
+	}
+
+	// other channels and groups are not affected
+	if from, to, _ := store.coverage("uuid", "day"); from != 0 || to != 0 {
+		t.Errorf("unexpected day coverage [%d, %d)", from, to)
+	}
+}
+
+func TestRollupStoreInvalidate(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	hour := func(h int) int64 {
+		return unixMS(base.Add(time.Duration(h) * time.Hour))
+	}
+
+	tc := []struct {
+		ts             int64
+		covFrom, covTo int64
+		stored         int
+	}{
+		{hour(30), hour(0), hour(24), 24},                                       // after coverage
+		{hour(24), hour(0), hour(24), 24},                                       // at coverage end
+		{hour(10) + int64(time.Minute/time.Millisecond), hour(0), hour(10), 10}, // truncates to period start
+		{hour(0), 0, 0, 0},                                                      // removes coverage
+		{hour(-5), 0, 0, 0},                                                     // before coverage
+	}
+
+	for _, c := range tc {
+		store, cleanup := newTestRollupStore(t, 0)
+
+		var tuples []volkszaehler.Tuple
+		for h := 0; h < 24; h++ {
+			tuples = append(tuples, volkszaehler.Tuple{Timestamp: hour(h), Value: 1})
+		}
+
+		if err := store.write("uuid", "hour", hour(0), hour(24), tuples); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.invalidate("uuid", c.ts); err != nil {
+			t.Fatal(err)
+		}
+
+		from, to, err := store.coverage("uuid", "hour")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if from != c.covFrom || to != c.covTo {
+			t.Errorf("invalidate %v: expected coverage [%d, %d), got [%d, %d)", fromUnixMS(c.ts), c.covFrom, c.covTo, from, to)
+		}
+
+		stored, err := store.read("uuid", "hour", hour(0), hour(48))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(stored) != c.stored {
+			t.Errorf("invalidate %v: expected %d stored tuples, got %d", fromUnixMS(c.ts), c.stored, len(stored))
+		}
+
+		cleanup()
+	}
+}
+
+func TestRollupStoreQueryData(t *testing.T) {
+	store, cleanup := newTestRollupStore(t, time.Hour)
+	defer cleanup()
+
+	api := &hourlyAPI{}
+
+	now := time.Now()
+	to := periodStart(now, "hour").Add(time.Hour)
+	from := to.Add(-12 * time.Hour)
+	cutoff := periodStart(now.Add(-time.Hour), "hour")
+
+	data, err := store.queryData(api, "uuid", from, to, "hour", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 12 || data[0].Timestamp != unixMS(from) {
+		t.Fatalf("expected 12 hourly tuples from %v, got %v", from, data)
+	}
+
+	// periods within grace are not stored
+	covFrom, covTo, err := store.coverage("uuid", "hour")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if covFrom != unixMS(from) || covTo != unixMS(cutoff) {
+		t.Errorf("expected coverage [%v, %v), got [%v, %v)", from, cutoff, fromUnixMS(covFrom), fromUnixMS(covTo))
+	}
+
+	// stored periods are not queried again
+	api.queries = nil
+	earlier := from.Add(-3 * time.Hour)
+
+	data, err = store.queryData(api, "uuid", earlier, to, "hour", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != 15 {
+		t.Errorf("expected 15 tuples, got %d", len(data))
+	}
+
+	expected := [][2]time.Time{{earlier, from}, {cutoff, to}}
+	if len(api.queries) != len(expected) {
+		t.Fatalf("expected queries %v, got %v", expected, api.queries)
+	}
+
+	for i, q := range api.queries {
+		if !q[0].Equal(expected[i][0]) || !q[1].Equal(expected[i][1]) {
+			t.Errorf("expected query %v, got %v", expected[i], q)
+		}
+	}
+
+	if covFrom, _, _ := store.coverage("uuid", "hour"); covFrom != unixMS(earlier) {
+		t.Errorf("expected coverage extended to %v, got %v", earlier, fromUnixMS(covFrom))
+	}
+
+	// results are packed into tuples
+	data, err = store.queryData(api, "uuid", earlier, to, "hour", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) > 5 {
+		t.Errorf("expected at most 5 tuples, got %d", len(data))
+	}
+}
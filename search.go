@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/andig/gravo/grafana"
+	"github.com/andig/gravo/volkszaehler"
+)
+
+// searchFilter selects entities from the flattened entity tree
+//
+// The search target consists of optional type:<type>[,<type>] terms and a
+// pattern applied to the entity title path:
+//
+//	House/          browse children of group path (/ for top level)
+//	~^House/.*Grid  regular expression
+//	House/*/Meter   glob
+//	grid            case-insensitive substring
+type searchFilter struct {
+	types  []string
+	browse bool
+	parent string
+	match  func(title string) bool
+}
+
+// normalizeType allows writing entity types like "electric meter" as electric_meter
+func normalizeType(typ string) string {
+	return strings.NewReplacer("_", " ", "-", " ").Replace(strings.ToLower(typ))
+}
+
+func parseSearchTarget(target string) (searchFilter, error) {
+	filter := searchFilter{
+		match: func(string) bool { return true },
+	}
+
+	var terms []string
+	for _, term := range strings.Fields(target) {
+		if strings.HasPrefix(strings.ToLower(term), "type:") {
+			for _, typ := range strings.Split(term[len("type:"):], ",") {
+				if typ != "" {
+					filter.types = append(filter.types, normalizeType(typ))
+				}
+			}
+			continue
+		}
+
+		terms = append(terms, term)
+	}
+
+	pattern := strings.Join(terms, " ")
+
+	switch {
+	case pattern == "":
+		// match all
+
+	case strings.HasSuffix(pattern, "/"):
+		filter.browse = true
+		filter.parent = strings.TrimPrefix(pattern, "/")
+
+	case strings.HasPrefix(pattern, "~"):
+		re, err := regexp.Compile(pattern[1:])
+		if err != nil {
+			return filter, fmt.Errorf("invalid search regex: %v", err)
+		}
+		filter.match = re.MatchString
+
+	case strings.ContainsAny(pattern, "*?["):
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return filter, fmt.Errorf("invalid search glob: %v", err)
+		}
+		filter.match = func(title string) bool {
+			ok, _ := path.Match(pattern, strings.ToLower(title))
+			return ok
+		}
+
+	default:
+		pattern = strings.ToLower(pattern)
+		filter.match = func(title string) bool {
+			return strings.Contains(strings.ToLower(title), pattern)
+		}
+	}
+
+	return filter, nil
+}
+
+func (filter searchFilter) matchType(entity volkszaehler.Entity) bool {
+	if len(filter.types) == 0 {
+		return true
+	}
+
+	typ := normalizeType(entity.Type)
+	for _, t := range filter.types {
+		if t == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply returns the search results for the given entities. In browse mode
+// groups below parent are returned with trailing slash, followed by the
+// channels directly contained in parent.
+func (filter searchFilter) apply(entities []volkszaehler.Entity) []grafana.SearchResponse {
+	res := []grafana.SearchResponse{}
+
+	if !filter.browse {
+		for _, entity := range entities {
+			if filter.matchType(entity) && filter.match(entity.Title) {
+				res = append(res, grafana.SearchResponse{
+					Text: entity.Title,
+					UUID: entity.UUID,
+				})
+			}
+		}
+
+		return res
+	}
+
+	groups := make(map[string]bool)
+	for _, entity := range entities {
+		if !strings.HasPrefix(entity.Title, filter.parent) || !filter.matchType(entity) {
+			continue
+		}
+
+		rel := entity.Title[len(filter.parent):]
+		if idx := strings.Index(rel, "/"); idx >= 0 {
+			groups[filter.parent+rel[:idx+1]] = true
+			continue
+		}
+
+		res = append(res, grafana.SearchResponse{
+			Text: entity.Title,
+			UUID: entity.UUID,
+		})
+	}
+
+	paths := make([]string, 0, len(groups))
+	for group := range groups {
+		paths = append(paths, group)
+	}
+	sort.Strings(paths)
+
+	folders := make([]grafana.SearchResponse, 0, len(paths)+len(res))
+	for _, group := range paths {
+		folders = append(folders, grafana.SearchResponse{
+			Text: group,
+			UUID: group,
+		})
+	}
+
+	return append(folders, res...)
+}
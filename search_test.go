@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/andig/gravo/volkszaehler"
+)
+
+func TestSearchFilter(t *testing.T) {
+	entities := []volkszaehler.Entity{
+		{UUID: "c1", Type: "power", Title: "House/Grid"},
+		{UUID: "c2", Type: "temperature", Title: "House/Living Room"},
+		{UUID: "c3", Type: "power", Title: "House/Heating/Pump"},
+		{UUID: "c4", Type: "electric meter", Title: "Garage"},
+		{UUID: "c5", Type: "temperature", Title: "Garden/Temp"},
+	}
+
+	tc := []struct {
+		target string
+		texts  []string
+		err    bool
+	}{
+		{"", []string{"House/Grid", "House/Living Room", "House/Heating/Pump", "Garage", "Garden/Temp"}, false},
+		// substring
+		{"grid", []string{"House/Grid"}, false},
+		{"living room", []string{"House/Living Room"}, false},
+		// glob, * does not match /
+		{"house/*", []string{"House/Grid", "House/Living Room"}, false},
+		{"House/*/Pump", []string{"House/Heating/Pump"}, false},
+		{"Gar?ge", []string{"Garage"}, false},
+		{"[", nil, true},
+		// regex
+		{"~^Ga", []string{"Garage", "Garden/Temp"}, false},
+		{"~^ga", []string{}, false},
+		{"~(", nil, true},
+		// types
+		{"type:temperature", []string{"House/Living Room", "Garden/Temp"}, false},
+		{"Type:Electric-Meter", []string{"Garage"}, false},
+		{"type:power,electric_meter house", []string{"House/Grid", "House/Heating/Pump"}, false},
+		{"type:", []string{"House/Grid", "House/Living Room", "House/Heating/Pump", "Garage", "Garden/Temp"}, false},
+		// browse, groups first
+		{"/", []string{"Garden/", "House/", "Garage"}, false},
+		{"House/", []string{"House/Heating/", "House/Grid", "House/Living Room"}, false},
+		{"type:temperature /", []string{"Garden/", "House/"}, false},
+		{"Nothing/", []string{}, false},
+	}
+
+	for _, c := range tc {
+		filter, err := parseSearchTarget(c.target)
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected error", c.target)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.target, err)
+			continue
+		}
+
+		texts := []string{}
+		for _, res := range filter.apply(entities) {
+			texts = append(texts, res.Text)
+		}
+
+		if !reflect.DeepEqual(texts, c.texts) {
+			t.Errorf("%q: expected %v, got %v", c.target, c.texts, texts)
+		}
+	}
+}
+
+func TestSearchFilterUUID(t *testing.T) {
+	entities := []volkszaehler.Entity{
+		{UUID: "c1", Type: "power", Title: "House/Grid"},
+		{UUID: "c2", Type: "power", Title: "House/Heating/Pump"},
+	}
+
+	filter, err := parseSearchTarget("House/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := filter.apply(entities)
+	if len(res) != 2 || res[0].UUID != "House/Heating/" || res[1].UUID != "c1" {
+		t.Errorf("expected group path and channel uuid, got %+v", res)
+	}
+}
@@ -15,23 +15,56 @@ import (
 
 // Server is the http endpoint used by Grafana's SimpleJson plugin
 type Server struct {
-	api         volkszaehler.Client
-	cacheMux    sync.Mutex // guards entityCache
-	entityCache map[string]string
+	api      volkszaehler.Client
+	cache    *entityCache
+	registry *entityRegistry
+	private  []string
+	done     chan struct{}
+	ingest   *ingestBuffer
+	tokens   map[string]string // ingest tokens by uuid
+	latest   *volkszaehler.LatestValues
+	rollups  *rollupStore
+	prewarm  *prewarmer
+	flushes  sync.WaitGroup // final flushes of write buffers on close
 }
 
-func newServer(api volkszaehler.Client) *Server {
+// newServer creates a server and populates the entity cache. If refresh
+// is positive, the cache is updated periodically in the background.
+// Private entities are included in search results.
+func newServer(api volkszaehler.Client, refresh time.Duration, private []string) *Server {
 	server := &Server{
-		api:         api,
-		entityCache: make(map[string]string),
+		api:      api,
+		cache:    newEntityCache(),
+		registry: newEntityRegistry(api),
+		private:  private,
+		done:     make(chan struct{}),
 	}
 
 	// get entity map on startup
-	server.getPublicEntites()
+	if err := server.refreshEntities(); err != nil {
+		log.Printf("api call failed: %v", err)
+	}
+
+	if refresh > 0 {
+		go server.refresh(refresh)
+	}
 
 	return server
 }
 
+// Close stops background processing and waits until buffered writes have
+// been flushed
+func (server *Server) Close() {
+	close(server.done)
+	server.flushes.Wait()
+
+	if server.rollups != nil {
+		if err := server.rollups.Close(); err != nil {
+			log.Printf("rollup store: %v", err)
+		}
+	}
+}
+
 func (server *Server) rootHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "ok\n")
 }
@@ -92,7 +125,12 @@ func (server *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := server.executeSearch()
+	resp, err := server.executeSearch(sr.Target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		log.Printf("json encode failed: %v", err)
@@ -102,63 +140,52 @@ func (server *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (server *Server) flattenEntities(result *[]volkszaehler.Entity, entities []volkszaehler.Entity, parent string) {
+// flattenEntities converts the entity tree into a list of channels with
+// title paths
+func flattenEntities(result *[]volkszaehler.Entity, entities []volkszaehler.Entity, parent string) {
 	for _, entity := range entities {
 		if parent != "" {
 			entity.Title = fmt.Sprintf("%s/%s", parent, entity.Title)
 		}
 		if entity.Type == "group" || entity.Type == "building" || entity.Type == "user"   {
-			server.flattenEntities(result, entity.Children, entity.Title)
+			flattenEntities(result, entity.Children, entity.Title)
 		} else {
 			*result = append(*result, entity)
 		}
 	}
 }
 
-func (server *Server) populateCache(entities []volkszaehler.Entity) {
-	server.cacheMux.Lock()
-	defer server.cacheMux.Unlock()
-
-	if len(entities) > 0 {
-		server.entityCache = make(map[string]string)
+// getPublicEntites returns the cached public entities. If the cache is
+// empty, e.g. because the middleware was unavailable at startup, it is
+// refreshed first.
+func (server *Server) getPublicEntites() []volkszaehler.Entity {
+	if entities := server.cache.entities(); len(entities) > 0 {
+		return entities
 	}
 
-	// add to cache
-	for _, entity := range entities {
-		if _, ok := server.entityCache[entity.UUID]; !ok {
-			server.entityCache[entity.UUID] = entity.Title
-		}
+	if err := server.refreshEntities(); err != nil {
+		log.Printf("api call failed: %v", err)
 	}
+
+	return server.cache.entities()
 }
 
-func (server *Server) getPublicEntites() []volkszaehler.Entity {
-	entities := make([]volkszaehler.Entity, 0)
+func (server *Server) executeSearch(target string) ([]grafana.SearchResponse, error) {
+	// Simple JSON datasource uses search for template variables
+	if isVariableQuery(target) {
+		return server.executeVariableSearch(target)
+	}
 
-	publicEntities, err := server.api.QueryPublicEntities()
+	filter, err := parseSearchTarget(target)
 	if err != nil {
-		log.Printf("api call failed: %v", err)
-
-		return entities
+		return nil, err
 	}
 
-	server.flattenEntities(&entities, publicEntities, "")
-	server.populateCache(entities)
-
-	return entities
-}
-
-func (server *Server) executeSearch() []grafana.SearchResponse {
 	entities := server.getPublicEntites()
+	// limit capacity to not modify the cached entities
+	entities = append(entities[:len(entities):len(entities)], server.getPrivateEntities()...)
 
-	res := []grafana.SearchResponse{}
-	for _, entity := range entities {
-		res = append(res, grafana.SearchResponse{
-			Text: entity.Title,
-			UUID: entity.UUID,
-		})
-	}
-
-	return res
+	return filter.apply(entities), nil
 }
 
 func (server *Server) queryHandler(w http.ResponseWriter, r *http.Request) {
@@ -170,7 +197,17 @@ func (server *Server) queryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := server.executeQuery(qr)
+	results := server.executeQuery(qr)
+	for _, qres := range results {
+		if qres.Error != nil {
+			log.Printf("query failed: %v", qres.Error)
+			http.Error(w, qres.Error.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	resp := queryResponses(qr, results)
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		log.Printf("json encode failed: %v", err)
@@ -181,19 +218,7 @@ func (server *Server) queryHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func roundTimestampMS(ts int64, group string) int64 {
-	const millisPerSecond = 1000
-	t := time.Unix(ts/millisPerSecond, 0)
-
-	switch group {
-	case "hour":
-		t.Truncate(time.Hour)
-	case "day":
-		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
-	case "month":
-		t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
-	}
-
-	return t.Unix() * 1000
+	return unixMS(periodStart(fromUnixMS(ts), group))
 }
 
 func (server *Server) executeQuery(qr grafana.QueryRequest) []grafana.QueryResponse {
@@ -204,46 +229,76 @@ func (server *Server) executeQuery(qr grafana.QueryRequest) []grafana.QueryRespo
 		wg.Add(1)
 
 		go func(idx int, target grafana.Target) {
-			var qres grafana.QueryResponse
+			res[idx] = server.executeTarget(target, &qr)
+			wg.Done()
+		}(idx, target)
+	}
 
-			context := strings.ToLower(target.Data.Context)
-			if context == "prognosis" {
-				qres = server.queryPrognosis(target)
-			} else {
-				qres = server.queryData(target, &qr)
-			}
+	wg.Wait()
 
-			// substitute name
-			server.cacheMux.Lock()
-			if text, ok := server.entityCache[qres.Target.(string)]; ok {
-				qres.Target = text
-			}
-			server.cacheMux.Unlock()
+	return res
+}
 
-			if target.Data.Name != "" {
-				qres.Target = target.Data.Name
-			}
+// streamQuery executes the query targets concurrently and sends each result
+// as soon as it is complete. The channel is closed after the last result.
+func (server *Server) streamQuery(qr grafana.QueryRequest) <-chan grafana.QueryResponse {
+	res := make(chan grafana.QueryResponse, len(qr.Targets))
+	wg := &sync.WaitGroup{}
 
-			res[idx] = qres
+	for _, target := range qr.Targets {
+		wg.Add(1)
 
+		go func(target grafana.Target) {
+			res <- server.executeTarget(target, &qr)
 			wg.Done()
-		}(idx, target)
+		}(target)
 	}
 
-	wg.Wait()
+	go func() {
+		wg.Wait()
+		close(res)
+	}()
 
 	return res
 }
 
+// executeTarget queries a single target and adds the series metadata
+func (server *Server) executeTarget(target grafana.Target, qr *grafana.QueryRequest) grafana.QueryResponse {
+	var qres grafana.QueryResponse
+
+	target = substituteTarget(target, qr.ScopedVars)
+
+	context := strings.ToLower(target.Data.Context)
+	if context == "prognosis" {
+		qres = server.queryPrognosis(target)
+	} else {
+		qres = server.queryData(target, qr)
+	}
+
+	server.addSeriesMetadata(&qres, target)
+
+	// substitute name
+	if title := qres.Labels["title"]; title != "" {
+		qres.Target = title
+	}
+
+	if target.Data.Name != "" {
+		qres.Target = target.Data.Name
+	}
+
+	return qres
+}
+
 func (server *Server) queryData(target grafana.Target, qr *grafana.QueryRequest) grafana.QueryResponse {
 	qres := grafana.QueryResponse{
 		Target:     target.Target,
 		Datapoints: []grafana.ResponseTuple{},
 	}
 
-	var group string
-	if target.Data.Group != "" {
-		group = strings.ToLower(target.Data.Group)
+	group, err := parseGroup(target.Data.Group)
+	if err != nil {
+		qres.Error = err
+		return qres
 	}
 
 	var options string
@@ -251,30 +306,43 @@ func (server *Server) queryData(target grafana.Target, qr *grafana.QueryRequest)
 		options = strings.ToLower(target.Data.Options)
 	}
 
-	tuples, err := server.api.QueryData(
-		target.Target,
-		qr.Range.From,
-		qr.Range.To,
-		group,
-		options,
-		qr.MaxDataPoints,
-	)
+	tuples := qr.MaxDataPoints
+
+	// query unpacked data for downsampling by gravo
+	downsampler := strings.ToLower(target.Data.Downsample)
+	apiTuples := tuples
+	if downsampler != "" && downsampler != "none" {
+		apiTuples = 0
+	}
+
+	query := func(from, to time.Time, apiGroup string, apiTuples int) ([]volkszaehler.Tuple, error) {
+		if server.rollups != nil && rollupGroups[apiGroup] && options == "" {
+			return server.rollups.queryData(server.api, target.Target, from, to, apiGroup, apiTuples)
+		}
+
+		return server.api.QueryData(target.Target, from, to, apiGroup, options, apiTuples)
+	}
+
+	data, err := queryGrouped(query, qr.Range.From, qr.Range.To, group, options, apiTuples)
 	if err != nil {
 		log.Printf("api call failed: %v", err)
 		return qres
 	}
 
-	for _, tuple := range tuples {
-		if group != "" {
-			tuple.Timestamp = roundTimestampMS(tuple.Timestamp, group)
-		}
+	data, err = aggregate(data, target.Data.Aggregate)
+	if err != nil {
+		qres.Error = err
+		return qres
+	}
 
-		qres.Datapoints = append(qres.Datapoints, grafana.ResponseTuple{
-			Timestamp: tuple.Timestamp,
-			Value:     tuple.Value,
-		})
+	data, err = downsample(data, downsampler, tuples)
+	if err != nil {
+		qres.Error = err
+		return qres
 	}
 
+	qres.Datapoints = responseTuples(data)
+
 	return qres
 }
 
@@ -293,7 +361,7 @@ func (server *Server) queryPrognosis(target grafana.Target) grafana.QueryRespons
 
 		qres.Datapoints = append(qres.Datapoints, grafana.ResponseTuple{
 			Value:     pr.Consumption,
-			Timestamp: time.Now().Unix(),
+			Timestamp: time.Now().Unix() * 1000,
 		})
 	}
 
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/andig/gravo/grafana"
+)
+
+// streamInterval is the default polling interval for new tuples
+const streamInterval = 5 * time.Second
+
+// tail passes new tuples of the channel to send until ctx is done or send
+// fails. Tuples are received from the push server if enabled or polled
+// from the middleware every interval otherwise.
+func (server *Server) tail(ctx context.Context, uuid string, interval time.Duration, send func(grafana.QueryResponse) error) error {
+	if server.latest != nil {
+		return server.tailPush(ctx, uuid, send)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last int64
+	from := time.Now().Add(-interval)
+
+	for {
+		data, err := server.api.QueryData(uuid, from, time.Now(), "", "", 0)
+		if err != nil {
+			log.Printf("api call failed: %v", err)
+		}
+
+		qres := grafana.QueryResponse{
+			Target:     uuid,
+			Datapoints: []grafana.ResponseTuple{},
+		}
+
+		for _, tuple := range data {
+			if tuple.Timestamp <= last || tuple.Null {
+				continue
+			}
+
+			qres.Datapoints = append(qres.Datapoints, grafana.ResponseTuple{
+				Timestamp: tuple.Timestamp,
+				Value:     tuple.Value,
+				Count:     tuple.Count,
+			})
+
+			last = tuple.Timestamp
+			from = time.Unix(0, last*int64(time.Millisecond))
+		}
+
+		if len(qres.Datapoints) > 0 {
+			if err := send(qres); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// tailPush passes the tuples received from the push server to send
+func (server *Server) tailPush(ctx context.Context, uuid string, send func(grafana.QueryResponse) error) error {
+	updates, cancel := server.latest.Subscribe(uuid)
+	defer cancel()
+
+	var last int64
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case tuples := <-updates:
+			qres := grafana.QueryResponse{
+				Target:     uuid,
+				Datapoints: []grafana.ResponseTuple{},
+			}
+
+			for _, tuple := range tuples {
+				if tuple.Timestamp <= last {
+					continue
+				}
+
+				qres.Datapoints = append(qres.Datapoints, grafana.ResponseTuple{
+					Timestamp: tuple.Timestamp,
+					Value:     tuple.Value,
+					Count:     tuple.Count,
+				})
+
+				last = tuple.Timestamp
+			}
+
+			if len(qres.Datapoints) > 0 {
+				if err := send(qres); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// streamHandler pushes new tuples of the target channels as server-sent
+// events. Each event contains the new data points of a single channel in
+// /query response format.
+//
+//	GET /stream?target=<uuid|title>[&target=...][&interval=5s]
+func (server *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(r.Form["target"]) == 0 {
+		http.Error(w, "missing target", http.StatusBadRequest)
+		return
+	}
+
+	interval := streamInterval
+	if s := r.FormValue("interval"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < time.Second {
+			http.Error(w, fmt.Sprintf("invalid interval: %s", s), http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	events := make(chan grafana.QueryResponse)
+
+	for _, target := range r.Form["target"] {
+		uuid := server.resolveUUID(target)
+
+		name := target
+		if entity, ok := server.entity(uuid); ok {
+			name = entity.Title
+		}
+
+		go func() {
+			_ = server.tail(ctx, uuid, interval, func(qres grafana.QueryResponse) error {
+				qres.Target = name
+
+				select {
+				case events <- qres:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+		}()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case qres := <-events:
+			b, err := json.Marshal(qres)
+			if err != nil {
+				log.Printf("json encode failed: %v", err)
+				continue
+			}
+
+			fmt.Fprintf(w, "event: data\ndata: %s\n\n", b)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/andig/gravo/grafana"
+)
+
+// granularities are the supported values of the group payload field
+var granularities = []string{"15m", "hour", "day", "week", "month", "quarter", "year"}
+
+// variableFunctions are the supported template variable functions
+var variableFunctions = map[string]bool{
+	"groups":        true,
+	"channels":      true,
+	"types":         true,
+	"granularities": true,
+}
+
+var (
+	variableQueryRE = regexp.MustCompile(`^\s*(\w+)\(\s*(.*?)\s*\)\s*$`)
+	variableRefRE   = regexp.MustCompile(`\$\{(\w+)\}|\$(\w+)|\[\[(\w+)\]\]`)
+)
+
+func (server *Server) variableHandler(w http.ResponseWriter, r *http.Request) {
+	vr := grafana.VariableRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&vr); err != nil {
+		log.Printf("json decode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json decode failed: %v", err), http.StatusBadRequest)
+
+		return
+	}
+
+	resp, err := server.executeVariable(vr.Target())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+
+		return
+	}
+}
+
+// isVariableQuery checks if query is a call of a template variable
+// function. Other queries like channel titles containing parentheses are
+// searches.
+func isVariableQuery(query string) bool {
+	match := variableQueryRE.FindStringSubmatch(query)
+	return match != nil && variableFunctions[strings.ToLower(match[1])]
+}
+
+// executeVariable evaluates a template variable query:
+//
+//	groups()             all group paths
+//	channels(<group>)    channels below group path, all channels if empty
+//	types()              entity types of all channels
+//	granularities()      supported group values
+func (server *Server) executeVariable(query string) ([]grafana.VariableResponse, error) {
+	match := variableQueryRE.FindStringSubmatch(query)
+	if match == nil {
+		return nil, fmt.Errorf("invalid variable query: %s", query)
+	}
+
+	fun, arg := strings.ToLower(match[1]), strings.Trim(match[2], `"'`)
+	res := []grafana.VariableResponse{}
+
+	switch fun {
+	case "groups":
+		groups := make(map[string]bool)
+		for _, entity := range server.getPublicEntites() {
+			segments := strings.Split(entity.Title, "/")
+			for i := 1; i < len(segments); i++ {
+				groups[strings.Join(segments[:i], "/")] = true
+			}
+		}
+
+		for _, group := range sortedKeys(groups) {
+			res = append(res, grafana.VariableResponse{Text: group, Value: group})
+		}
+
+	case "channels":
+		prefix := strings.Trim(arg, "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+
+		for _, entity := range server.getPublicEntites() {
+			if strings.HasPrefix(entity.Title, prefix) {
+				res = append(res, grafana.VariableResponse{Text: entity.Title, Value: entity.UUID})
+			}
+		}
+
+	case "types":
+		types := make(map[string]bool)
+		for _, entity := range server.getPublicEntites() {
+			types[entity.Type] = true
+		}
+
+		for _, typ := range sortedKeys(types) {
+			res = append(res, grafana.VariableResponse{Text: typ, Value: typ})
+		}
+
+	case "granularities":
+		for _, group := range granularities {
+			res = append(res, grafana.VariableResponse{Text: group, Value: group})
+		}
+
+	default:
+		return nil, fmt.Errorf("invalid variable function: %s", fun)
+	}
+
+	return res, nil
+}
+
+// executeVariableSearch evaluates a template variable query in search format
+func (server *Server) executeVariableSearch(query string) ([]grafana.SearchResponse, error) {
+	vars, err := server.executeVariable(query)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]grafana.SearchResponse, 0, len(vars))
+	for _, v := range vars {
+		res = append(res, grafana.SearchResponse{Text: v.Text, UUID: v.Value})
+	}
+
+	return res, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// variableValue formats a template variable value. Multiple values are
+// joined by comma.
+func variableValue(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, val := range v {
+			values = append(values, variableValue(val))
+		}
+		return strings.Join(values, ",")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// substituteVariables replaces $var, ${var} and [[var]] references with
+// their values. Unknown variables are left untouched.
+func substituteVariables(s string, vars grafana.ScopedVars) string {
+	if len(vars) == 0 || !strings.ContainsAny(s, "$[") {
+		return s
+	}
+
+	return variableRefRE.ReplaceAllStringFunc(s, func(ref string) string {
+		match := variableRefRE.FindStringSubmatch(ref)
+		name := match[1] + match[2] + match[3]
+
+		if v, ok := vars[name]; ok {
+			return variableValue(v.Value)
+		}
+
+		return ref
+	})
+}
+
+// substituteTarget replaces template variables in target and payload
+func substituteTarget(target grafana.Target, vars grafana.ScopedVars) grafana.Target {
+	target.Target = substituteVariables(target.Target, vars)
+	target.Data.Context = substituteVariables(target.Data.Context, vars)
+	target.Data.Group = substituteVariables(target.Data.Group, vars)
+	target.Data.Options = substituteVariables(target.Data.Options, vars)
+	target.Data.Name = substituteVariables(target.Data.Name, vars)
+	target.Data.Period = substituteVariables(target.Data.Period, vars)
+	target.Data.Aggregate = substituteVariables(target.Data.Aggregate, vars)
+	target.Data.Downsample = substituteVariables(target.Data.Downsample, vars)
+
+	return target
+}
@@ -28,6 +28,11 @@ type Client interface {
 	QueryPrognosis(uuid string, period string) (Prognosis, error)
 }
 
+// Writer is implemented by clients that can write data to the middleware
+type Writer interface {
+	PostData(uuid string, tuples []Tuple) (int, error)
+}
+
 type client struct {
 	url    string
 	client HTTPDoer
@@ -88,6 +93,15 @@ func (api *client) Get(endpoint string) (io.ReadCloser, error) {
 // Post returns a GET requests body or error. It is the clients responsibility
 // to close the response body in case error is not nil
 func (api *client) Post(endpoint string, payload string) (io.ReadCloser, error) {
+	resp, err := api.post(endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (api *client) post(endpoint string, payload string) (*http.Response, error) {
 	url := api.url + endpoint
 
 	req, err := http.NewRequest("POST", url, strings.NewReader(payload))
@@ -109,7 +123,7 @@ func (api *client) Post(endpoint string, payload string) (io.ReadCloser, error)
 		}
 	}
 
-	return resp.Body, nil
+	return resp, nil
 }
 
 // QueryPublicEntities retrieves public entities from middleware
@@ -225,3 +239,40 @@ func (api *client) QueryPrognosis(uuid string, period string) (Prognosis, error)
 
 	return pr.Prognosis, nil
 }
+
+// PostData adds tuples to the channel and returns the number of rows written
+func (api *client) PostData(uuid string, tuples []Tuple) (int, error) {
+	values := make([][]interface{}, 0, len(tuples))
+	for _, tuple := range tuples {
+		values = append(values, []interface{}{tuple.Timestamp, tuple.Value})
+	}
+
+	payload, err := json.Marshal(values)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := api.post(fmt.Sprintf("/data/%s.json", uuid), string(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = resp.Body.Close() // close body after checking for error
+	}()
+
+	pr := PostDataResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return 0, fmt.Errorf("%s: %v", resp.Status, err)
+	}
+
+	if pr.Exception.Message != "" {
+		return 0, &ExceptionError{Exception: pr.Exception, StatusCode: resp.StatusCode}
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return 0, errors.New(resp.Status)
+	}
+
+	return pr.Rows, nil
+}
@@ -1,6 +1,10 @@
 package volkszaehler
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
 
 // EntityType represent the entity types enum
 type EntityType string
@@ -32,6 +36,8 @@ type Entity struct {
 	Type     string   `json:"type"`
 	Title    string   `json:"title"`
 	Children []Entity `json:"children"`
+	// Properties holds all further entity properties like unit or color
+	Properties map[string]interface{} `json:"-"`
 }
 
 // DataResponse is the middleware response to /data.json
@@ -47,10 +53,14 @@ type Data struct {
 	Tuples []Tuple `json:"tuples"`
 }
 
-// Tuple is a single timestamp/value tuple
+// Tuple is a single timestamp/value/count tuple
 type Tuple struct {
 	Timestamp int64
-	Value     float32
+	Value     float64
+	// Null indicates that the middleware returned no value
+	Null bool
+	// Count is the number of raw readings aggregated into the tuple
+	Count int
 }
 
 // PrognosisResponse is the middleware response to /prognosis.json
@@ -62,8 +72,8 @@ type PrognosisResponse struct {
 
 // Prognosis is the prognosis result
 type Prognosis struct {
-	Consumption float32 `json:"consumption"`
-	Factor      float32 `json:"factor"`
+	Consumption float64 `json:"consumption"`
+	Factor      float64 `json:"factor"`
 }
 
 // Exception is the middleware exception structure
@@ -73,6 +83,23 @@ type Exception struct {
 	Code    int    `json:"code"`
 }
 
+// ExceptionError is a middleware exception returned as error
+type ExceptionError struct {
+	Exception
+	StatusCode int
+}
+
+func (e *ExceptionError) Error() string {
+	return "api exception: " + e.Message
+}
+
+// Permanent returns true if the request was rejected by the middleware,
+// e.g. due to invalid data or unknown channels, and must not be retried.
+// Server errors like unavailable databases are temporary.
+func (e *ExceptionError) Permanent() bool {
+	return e.StatusCode < http.StatusInternalServerError
+}
+
 // PostDataResponse is the middleware response to POST requests to /data.json
 type PostDataResponse struct {
 	Version   string    `json:"version"`
@@ -80,20 +107,62 @@ type PostDataResponse struct {
 	Rows      int       `json:"rows"`
 }
 
-// UnmarshalJSON converts volkszaehler tuple into Tuple struct
+// UnmarshalJSON converts volkszaehler tuple into Tuple struct. Tuples are
+// encoded as [timestamp, value] or [timestamp, value, count] where value
+// may be null.
 func (t *Tuple) UnmarshalJSON(b []byte) error {
-	var a []*json.RawMessage
+	var a []json.RawMessage
 	if err := json.Unmarshal(b, &a); err != nil {
 		return err
 	}
 
-	if err := json.Unmarshal(*a[0], &t.Timestamp); err != nil {
+	if len(a) < 2 {
+		return fmt.Errorf("invalid tuple: %s", string(b))
+	}
+
+	if err := json.Unmarshal(a[0], &t.Timestamp); err != nil {
 		return err
 	}
 
-	if err := json.Unmarshal(*a[1], &t.Value); err != nil {
+	var value *float64
+	if err := json.Unmarshal(a[1], &value); err != nil {
 		return err
 	}
 
+	t.Null = value == nil
+	if value != nil {
+		t.Value = *value
+	}
+
+	if len(a) > 2 {
+		if err := json.Unmarshal(a[2], &t.Count); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UnmarshalJSON converts volkszaehler entity into Entity struct. Properties
+// not mapped to struct fields are collected in Properties.
+func (e *Entity) UnmarshalJSON(b []byte) error {
+	type entity Entity // prevent recursion
+	var ent entity
+	if err := json.Unmarshal(b, &ent); err != nil {
+		return err
+	}
+
+	var props map[string]interface{}
+	if err := json.Unmarshal(b, &props); err != nil {
+		return err
+	}
+
+	for _, key := range []string{"uuid", "type", "title", "children"} {
+		delete(props, key)
+	}
+
+	*e = Entity(ent)
+	e.Properties = props
+
 	return nil
 }
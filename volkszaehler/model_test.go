@@ -0,0 +1,78 @@
+package volkszaehler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDataResponse(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/data.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dr DataResponse
+	if err := json.Unmarshal(b, &dr); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Tuple{
+		{Timestamp: 1600000060000, Value: 123456.789, Count: 1},
+		{Timestamp: 1600000120000, Value: 123456.812, Count: 3},
+		{Timestamp: 1600000180000, Null: true},
+		{Timestamp: 1600000240000, Value: 123457.005, Count: 2},
+	}
+
+	if len(dr.Data.Tuples) != len(expected) {
+		t.Fatalf("expected %d tuples, got %d", len(expected), len(dr.Data.Tuples))
+	}
+
+	for i, tuple := range dr.Data.Tuples {
+		if tuple != expected[i] {
+			t.Errorf("tuple %d: expected %+v, got %+v", i, expected[i], tuple)
+		}
+	}
+}
+
+func TestTupleUnmarshalJSON(t *testing.T) {
+	tc := []struct {
+		json  string
+		tuple Tuple
+		err   bool
+	}{
+		{`[1600000000000,12.5]`, Tuple{Timestamp: 1600000000000, Value: 12.5}, false},
+		{`[1600000000000,12.5,3]`, Tuple{Timestamp: 1600000000000, Value: 12.5, Count: 3}, false},
+		{`[1600000000000,123456.789,1]`, Tuple{Timestamp: 1600000000000, Value: 123456.789, Count: 1}, false},
+		{`[1600000000000,0,1]`, Tuple{Timestamp: 1600000000000, Count: 1}, false},
+		{`[1600000000000,null]`, Tuple{Timestamp: 1600000000000, Null: true}, false},
+		{`[1600000000000,null,0]`, Tuple{Timestamp: 1600000000000, Null: true}, false},
+		{`[1600000000000]`, Tuple{}, true},
+		{`[]`, Tuple{}, true},
+		{`{}`, Tuple{}, true},
+		{`["foo",1]`, Tuple{}, true},
+		{`[1600000000000,"foo"]`, Tuple{}, true},
+		{`[1600000000000,1,"foo"]`, Tuple{}, true},
+	}
+
+	for _, c := range tc {
+		var tuple Tuple
+		err := json.Unmarshal([]byte(c.json), &tuple)
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected error", c.json)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.json, err)
+			continue
+		}
+
+		if tuple != c.tuple {
+			t.Errorf("%s: expected %+v, got %+v", c.json, c.tuple, tuple)
+		}
+	}
+}
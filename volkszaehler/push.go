@@ -0,0 +1,231 @@
+package volkszaehler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// pushMinBackoff is the initial delay before reconnecting
+	pushMinBackoff = time.Second
+	// pushMaxBackoff is the maximum delay before reconnecting
+	pushMaxBackoff = time.Minute
+)
+
+// PushData is a single channel update broadcast by the push server
+type PushData struct {
+	UUID   string  `json:"uuid"`
+	Tuples []Tuple `json:"tuples"`
+}
+
+// PushMessage is the push server broadcast message. Data is either a single
+// channel update or a list of updates.
+type PushMessage struct {
+	Data json.RawMessage `json:"data"`
+}
+
+// DecodePushMessage decodes a push server message into tuples by uuid
+//
+//	{"data":{"uuid":"...","tuples":[[timestamp,value,count],...]}}
+func DecodePushMessage(b []byte) (map[string][]Tuple, error) {
+	var msg PushMessage
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return nil, err
+	}
+
+	var updates []PushData
+	if err := json.Unmarshal(msg.Data, &updates); err != nil {
+		var update PushData
+		if err := json.Unmarshal(msg.Data, &update); err != nil {
+			return nil, fmt.Errorf("invalid push message: %s", string(b))
+		}
+
+		updates = []PushData{update}
+	}
+
+	res := make(map[string][]Tuple)
+	for _, update := range updates {
+		if update.UUID == "" {
+			return nil, fmt.Errorf("missing uuid: %s", string(b))
+		}
+
+		res[update.UUID] = append(res[update.UUID], update.Tuples...)
+	}
+
+	return res, nil
+}
+
+// subscriberBuffer is the number of updates buffered per subscriber
+const subscriberBuffer = 16
+
+// LatestValues holds the latest non-null tuple per channel and passes
+// updates to subscribers
+type LatestValues struct {
+	mux         sync.RWMutex // guards tuples and subscribers
+	tuples      map[string]Tuple
+	subscribers map[string]map[chan []Tuple]struct{}
+}
+
+// NewLatestValues creates an empty latest-value store
+func NewLatestValues() *LatestValues {
+	return &LatestValues{
+		tuples:      make(map[string]Tuple),
+		subscribers: make(map[string]map[chan []Tuple]struct{}),
+	}
+}
+
+// Update stores the latest non-null tuple of the channel unless a newer
+// tuple is already known. The non-null tuples are sent to subscribers of
+// the channel.
+func (store *LatestValues) Update(uuid string, tuples []Tuple) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+
+	values := make([]Tuple, 0, len(tuples))
+	for _, tuple := range tuples {
+		if tuple.Null {
+			continue
+		}
+
+		values = append(values, tuple)
+
+		if latest, ok := store.tuples[uuid]; !ok || tuple.Timestamp >= latest.Timestamp {
+			store.tuples[uuid] = tuple
+		}
+	}
+
+	if len(values) == 0 {
+		return
+	}
+
+	for ch := range store.subscribers[uuid] {
+		// slow subscribers miss updates instead of blocking the push client
+		select {
+		case ch <- values:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the non-null tuples of each update
+// of the channel. The returned function must be called to unsubscribe.
+func (store *LatestValues) Subscribe(uuid string) (<-chan []Tuple, func()) {
+	ch := make(chan []Tuple, subscriberBuffer)
+
+	store.mux.Lock()
+	if store.subscribers[uuid] == nil {
+		store.subscribers[uuid] = make(map[chan []Tuple]struct{})
+	}
+	store.subscribers[uuid][ch] = struct{}{}
+	store.mux.Unlock()
+
+	return ch, func() {
+		store.mux.Lock()
+		delete(store.subscribers[uuid], ch)
+		if len(store.subscribers[uuid]) == 0 {
+			delete(store.subscribers, uuid)
+		}
+		store.mux.Unlock()
+	}
+}
+
+// Latest returns the latest tuple of the channel
+func (store *LatestValues) Latest(uuid string) (Tuple, bool) {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+
+	tuple, ok := store.tuples[uuid]
+	return tuple, ok
+}
+
+// PushClient receives channel updates from the volkszaehler push server
+type PushClient struct {
+	url    string
+	store  *LatestValues
+	dialer *websocket.Dialer
+}
+
+// NewPushClient creates a push server client feeding the store
+func NewPushClient(url string, store *LatestValues) *PushClient {
+	return &PushClient{
+		url:    url,
+		store:  store,
+		dialer: websocket.DefaultDialer,
+	}
+}
+
+// Run receives updates until done is closed. The connection is
+// re-established with exponential backoff.
+func (pc *PushClient) Run(done chan struct{}) {
+	backoff := pushMinBackoff
+
+	for {
+		connected, err := pc.receive(done)
+		if connected {
+			backoff = pushMinBackoff
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		log.Printf("push connection failed: %v (retry in %v)", err, backoff)
+
+		select {
+		case <-done:
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > pushMaxBackoff {
+			backoff = pushMaxBackoff
+		}
+	}
+}
+
+// receive connects to the push server and stores received updates until
+// the connection fails or done is closed
+func (pc *PushClient) receive(done chan struct{}) (bool, error) {
+	conn, _, err := pc.dialer.Dial(pc.url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("push connected: %s", pc.url)
+
+	// unblock reading when done
+	closed := make(chan struct{})
+	defer close(closed)
+
+	go func() {
+		select {
+		case <-done:
+		case <-closed:
+		}
+		conn.Close()
+	}()
+
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+
+		updates, err := DecodePushMessage(b)
+		if err != nil {
+			log.Printf("push message ignored: %v", err)
+			continue
+		}
+
+		for uuid, tuples := range updates {
+			pc.store.Update(uuid, tuples)
+		}
+	}
+}
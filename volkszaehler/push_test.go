@@ -0,0 +1,134 @@
+package volkszaehler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodePushMessage(t *testing.T) {
+	tc := []struct {
+		json    string
+		updates map[string][]Tuple
+		err     bool
+	}{
+		{
+			`{"data":{"uuid":"a","tuples":[[1600000000000,12.5,1]]}}`,
+			map[string][]Tuple{"a": {{Timestamp: 1600000000000, Value: 12.5, Count: 1}}},
+			false,
+		},
+		{
+			`{"version":"0.3","data":{"uuid":"a","tuples":[[1600000000000,null,0],[1600000001000,1]]}}`,
+			map[string][]Tuple{"a": {{Timestamp: 1600000000000, Null: true}, {Timestamp: 1600000001000, Value: 1}}},
+			false,
+		},
+		{
+			`{"data":[{"uuid":"a","tuples":[[1600000000000,1]]},{"uuid":"b","tuples":[[1600000000000,2]]},{"uuid":"a","tuples":[[1600000001000,3]]}]}`,
+			map[string][]Tuple{
+				"a": {{Timestamp: 1600000000000, Value: 1}, {Timestamp: 1600000001000, Value: 3}},
+				"b": {{Timestamp: 1600000000000, Value: 2}},
+			},
+			false,
+		},
+		{`{"data":[]}`, map[string][]Tuple{}, false},
+		{`{"data":{"tuples":[[1600000000000,1]]}}`, nil, true},
+		{`{"data":[{"uuid":"a"},{"tuples":[]}]}`, nil, true},
+		{`{"data":{"uuid":"a","tuples":[["foo",1]]}}`, nil, true},
+		{`{"data":"foo"}`, nil, true},
+		{`{}`, nil, true},
+		{`foo`, nil, true},
+	}
+
+	for _, c := range tc {
+		updates, err := DecodePushMessage([]byte(c.json))
+
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected error", c.json)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.json, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(updates, c.updates) {
+			t.Errorf("%s: expected %v, got %v", c.json, c.updates, updates)
+		}
+	}
+}
+
+func TestLatestValuesUpdate(t *testing.T) {
+	store := NewLatestValues()
+
+	if _, ok := store.Latest("a"); ok {
+		t.Error("expected no value")
+	}
+
+	store.Update("a", []Tuple{{Timestamp: 2, Value: 2}, {Timestamp: 3, Null: true}})
+	store.Update("a", []Tuple{{Timestamp: 1, Value: 1}})
+	store.Update("a", []Tuple{{Timestamp: 4, Null: true}})
+	store.Update("b", []Tuple{{Timestamp: 1, Value: 10}, {Timestamp: 5, Value: 50}, {Timestamp: 3, Value: 30}})
+
+	expected := map[string]Tuple{
+		"a": {Timestamp: 2, Value: 2},
+		"b": {Timestamp: 5, Value: 50},
+	}
+
+	for uuid, e := range expected {
+		if tuple, ok := store.Latest(uuid); !ok || tuple != e {
+			t.Errorf("%s: expected %+v, got %+v", uuid, e, tuple)
+		}
+	}
+}
+
+func TestLatestValuesSubscribe(t *testing.T) {
+	store := NewLatestValues()
+
+	updates, cancel := store.Subscribe("a")
+	other, cancelOther := store.Subscribe("a")
+	defer cancelOther()
+
+	store.Update("a", []Tuple{{Timestamp: 1, Value: 1}, {Timestamp: 2, Null: true}})
+	store.Update("a", []Tuple{{Timestamp: 3, Null: true}}) // not sent
+	store.Update("b", []Tuple{{Timestamp: 1, Value: 1}})   // other channel
+
+	for _, ch := range []<-chan []Tuple{updates, other} {
+		select {
+		case tuples := <-ch:
+			if !reflect.DeepEqual(tuples, []Tuple{{Timestamp: 1, Value: 1}}) {
+				t.Errorf("unexpected update: %v", tuples)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("missing update")
+		}
+
+		select {
+		case tuples := <-ch:
+			t.Errorf("unexpected update: %v", tuples)
+		default:
+		}
+	}
+
+	// no updates after unsubscribe
+	cancel()
+	store.Update("a", []Tuple{{Timestamp: 4, Value: 4}})
+
+	select {
+	case tuples := <-updates:
+		t.Errorf("unexpected update after unsubscribe: %v", tuples)
+	default:
+	}
+
+	// slow subscribers do not block updates
+	<-other
+	for i := 0; i < 2*subscriberBuffer; i++ {
+		store.Update("a", []Tuple{{Timestamp: int64(10 + i), Value: 1}})
+	}
+
+	if len(other) != subscriberBuffer {
+		t.Errorf("expected %d buffered updates, got %d", subscriberBuffer, len(other))
+	}
+}